@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/go-redis/redis"
+	"github.com/samber/lo"
+	"github.com/sirupsen/logrus"
+)
+
+var ActiveCommandDefinition = &discordgo.ApplicationCommand{
+	Name:        "active",
+	Description: "Show registrations that have not expired yet",
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "mine",
+			Description: "Show your active registrations",
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "all",
+			Description: "Show every member's active registrations at this server's properties (admin only)",
+		},
+	},
+}
+
+// GetActiveRegistrations returns a member's unexpired registrations, keeping only the latest per property & plate.
+func GetActiveRegistrations(member int) []HistoryEntry {
+	now := time.Now()
+	active := lo.Filter(GetHistory(member), func(entry HistoryEntry, _ int) bool {
+		return entry.Approved && entry.ExpiresAt.After(now)
+	})
+
+	// History is newest first, so the first of each property & plate is the latest
+	return lo.UniqBy(active, func(entry HistoryEntry) string {
+		return fmt.Sprintf("%d:%s", entry.PropertyId, entry.Plate)
+	})
+}
+
+// GetPropertyRegistrations returns every member's unexpired registrations at a property.
+func GetPropertyRegistrations(propertyId uint) []HistoryEntry {
+	key := fmt.Sprintf("active:%d", propertyId)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+
+	// Expired registrations are no longer of interest
+	db.ZRemRangeByScore(key, "-inf", now)
+
+	entries := make([]HistoryEntry, 0)
+	for _, raw := range db.ZRevRangeByScore(key, redis.ZRangeBy{Min: now, Max: "+inf"}).Val() {
+		var entry HistoryEntry
+		if err := unsealJSON(raw, &entry); err != nil {
+			logrus.WithFields(logrus.Fields{"error": err, "propertyId": propertyId}).Warn("Failed to decode active registration")
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	return lo.UniqBy(entries, func(entry HistoryEntry) string {
+		return fmt.Sprintf("%d:%s", entry.Member, entry.Plate)
+	})
+}
+
+// IsAutoRenewing returns true if the member has auto-renew enabled for a registration of the same property & plate.
+func IsAutoRenewing(entry HistoryEntry, renewals []Renewal) bool {
+	return lo.ContainsBy(renewals, func(renewal Renewal) bool {
+		return renewal.Submission.PropertyId == entry.PropertyId &&
+			strings.EqualFold(renewal.Submission.Values["vehicleLicensePlate"], entry.Plate)
+	})
+}
+
+// ActiveRegistrationField describes an active registration as an embed field.
+func ActiveRegistrationField(entry HistoryEntry, renewing bool, owner string) *discordgo.MessageEmbedField {
+	details := []string{
+		fmt.Sprintf("Code `%s`", entry.ConfirmationCode),
+		fmt.Sprintf("Expires <t:%d:R>", entry.ExpiresAt.Unix()),
+	}
+	if renewing {
+		details = append(details, ":repeat: Auto-renew")
+	}
+	if owner != "" {
+		details = append([]string{owner}, details...)
+	}
+
+	return &discordgo.MessageEmbedField{
+		Name:  fmt.Sprintf("%s — %s", entry.PropertyName, entry.Plate),
+		Value: strings.Join(details, " • "),
+	}
+}
+
+func ActiveCommandHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+	log := logrus.WithFields(logrus.Fields{
+		"interaction": interaction.ID,
+		"user":        GetUser(interaction).ID,
+		"command":     "active",
+	})
+
+	embed := &discordgo.MessageEmbed{
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: GetFooterText(),
+		},
+		Fields: make([]*discordgo.MessageEmbedField, 0),
+	}
+	deferred := false
+
+	switch interaction.ApplicationCommandData().Options[0].Name {
+	case "mine":
+		userId, parseErr := strconv.Atoi(GetUser(interaction).ID)
+		if parseErr != nil {
+			HandleError(session, interaction, parseErr, "Error occurred while parsing user id")
+			return
+		}
+
+		renewals := GetRenewals(userId)
+		for _, entry := range GetActiveRegistrations(userId) {
+			embed.Fields = append(embed.Fields, ActiveRegistrationField(entry, IsAutoRenewing(entry, renewals), ""))
+		}
+		embed.Title = "Your Active Registrations"
+
+	case "all":
+		if interaction.GuildID == "" || !IsGuildAdmin(interaction) {
+			HandleError(session, interaction, nil, ":x: Only server administrators can view everyone's registrations.")
+			return
+		}
+
+		config := GetGuildConfig(interaction.GuildID)
+		if len(config.Properties) == 0 {
+			HandleError(session, interaction, nil, ":x: This server has no properties configured. Add some with `/config add-property`.")
+			return
+		}
+
+		// Looking up members who aren't cached can take longer than Discord waits for a response
+		err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Flags: discordgo.MessageFlagsEphemeral,
+			},
+		})
+		if err != nil {
+			log.WithField("error", err).Error("Failed to defer active registrations")
+			return
+		}
+		deferred = true
+
+		// Only registrations belonging to members of this guild are shown
+		membership := map[int]bool{}
+		isMember := func(member int) bool {
+			if known, ok := membership[member]; ok {
+				return known
+			}
+			if _, err := session.State.Member(interaction.GuildID, strconv.Itoa(member)); err == nil {
+				membership[member] = true
+				return true
+			}
+			_, err := session.GuildMember(interaction.GuildID, strconv.Itoa(member))
+			membership[member] = err == nil
+			return membership[member]
+		}
+
+		renewals := map[int][]Renewal{}
+		for _, propertyId := range config.Properties {
+			for _, entry := range GetPropertyRegistrations(propertyId) {
+				if !isMember(entry.Member) {
+					continue
+				}
+				if _, ok := renewals[entry.Member]; !ok {
+					renewals[entry.Member] = GetRenewals(entry.Member)
+				}
+
+				embed.Fields = append(embed.Fields, ActiveRegistrationField(entry, IsAutoRenewing(entry, renewals[entry.Member]), fmt.Sprintf("<@%d>", entry.Member)))
+			}
+		}
+		embed.Title = "Active Registrations"
+	}
+
+	if len(embed.Fields) == 0 {
+		embed.Description = "No active registrations."
+	} else if len(embed.Fields) > 25 {
+		// Embeds cannot contain more than 25 fields
+		embed.Description = fmt.Sprintf("Showing 25 of %d active registrations.", len(embed.Fields))
+		embed.Fields = embed.Fields[:25]
+	}
+
+	var err error
+	if deferred {
+		_, err = session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{
+			Embeds:          &[]*discordgo.MessageEmbed{embed},
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		})
+	} else {
+		err = session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Embeds:          []*discordgo.MessageEmbed{embed},
+				Flags:           discordgo.MessageFlagsEphemeral,
+				AllowedMentions: &discordgo.MessageAllowedMentions{},
+			},
+		})
+	}
+	if err != nil {
+		log.WithField("error", err).Error("Failed to respond with active registrations")
+	}
+}
@@ -7,17 +7,26 @@ import (
 	"io"
 	"net/http"
 	"net/http/cookiejar"
+	"net/http/httptrace"
 	"net/url"
+	"os"
 	"regexp"
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
+	"github.com/samber/lo"
 	log "github.com/sirupsen/logrus"
 )
 
+// errUpstreamUnavailable indicates a request was rate limited or couldn't be sent, so Register2Park didn't act on it
+// and it may succeed if retried later.
+var errUpstreamUnavailable = errors.New("register2park is unavailable")
+
 var (
 	client             *http.Client
 	requestCounter     uint
@@ -25,6 +34,7 @@ var (
 	parsePattern       = regexp.MustCompile(`\s*(.+)\n\s+(.+)\n\s+(\d+)\s*`)
 	vehicleIdPattern   = regexp.MustCompile(`data-vehicle-id="(\d+)"`)
 	timestampPattern   = regexp.MustCompile(`\d{4}-\d{2}-\d{2} \d{2}:\d{2} [AP]M`)
+	approvalPattern    = regexp.MustCompile(`(?i)for\s+(\d+)\s+(hour|day)s?`)
 	cachedLocations    []Location
 	cachedLocationsMap map[uint]Location
 	cacheExpiry        time.Time
@@ -37,7 +47,7 @@ func init() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	client = &http.Client{Jar: cookies}
+	client = &http.Client{Jar: cookies, Transport: &RecordingTransport{}}
 }
 
 func onRequest(req *http.Request) {
@@ -49,6 +59,10 @@ func onRequest(req *http.Request) {
 }
 
 func onResponse(res *http.Response) {
+	// Requests that failed outright (e.g. timeouts) have no response to log
+	if res == nil {
+		return
+	}
 	log.WithFields(log.Fields{
 		"status": res.Status,
 		"length": res.ContentLength,
@@ -61,14 +75,14 @@ func onResponse(res *http.Response) {
 func reload() {
 	// Ring the doorbell
 	req := BuildRequest("GET", "/", nil)
-	doRequest(req)
+	doRequest(Capture{}, req)
 
 	// Ring the second doorbell (seems to be a way of validating whether a client is a 'browser' or not)
 	req = BuildRequest("GET", "/index.php", map[string]string{
 		"width":  "1920",
 		"height": "1080",
 	})
-	doRequest(req)
+	doRequest(Capture{}, req)
 
 	// Verify that a PHPSESSID cookie is present
 	site_cookies := client.Jar.Cookies(req.URL)
@@ -115,7 +129,7 @@ func GetLocations() []Location {
 	req := BuildRequestWithBody("POST", "/register-get-properties-from-name", nil, bytes.NewBufferString(body))
 	SetTypicalHeaders(req, nil, nil, true)
 
-	res, err := doRequest(req)
+	res, err := doRequest(Capture{}, req)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -168,12 +182,29 @@ type GetFormResult struct {
 	err               error // any error that occurred
 }
 
-func GetForm(id uint) GetFormResult {
+// NewFormSchema describes a retrieved form in a cacheable form.
+func NewFormSchema(form GetFormResult, guestCode bool) FormSchema {
+	return FormSchema{
+		PropertyName: form.propertyName,
+		Address:      form.address,
+		Fields: lo.Map(form.fields, func(field Field, _ int) SchemaField {
+			return SchemaField{ID: field.id, Text: field.text}
+		}),
+		HiddenInputs: form.hiddenInputs,
+		GuestCode:    guestCode,
+		FetchedAt:    time.Now(),
+	}
+}
+
+func GetForm(capture Capture, id uint) GetFormResult {
 	body := fmt.Sprintf("propertyIdSelected=%d&propertySource=parking-snap", id)
 	req := BuildRequestWithBody("POST", "/register-get-vehicle-form", nil, bytes.NewBufferString(body))
 	SetTypicalHeaders(req, nil, nil, false)
 
-	res, _ := doRequest(req)
+	res, err := doRequest(capture.at(id), req)
+	if err != nil {
+		return GetFormResult{err: err}
+	}
 
 	// Read and parse the HTML response body
 	html, _ := io.ReadAll(res.Body)
@@ -213,12 +244,15 @@ func GetForm(id uint) GetFormResult {
 	}
 }
 
-func GetVipForm(id uint, guestCode string) GetFormResult {
+func GetVipForm(capture Capture, id uint, guestCode string) GetFormResult {
 	body := fmt.Sprintf("propertyIdSelected=%d&propertySource=parking-snap&guestCode=%s", id, guestCode)
 	req := BuildRequestWithBody("POST", "/register-get-vip-vehicle-form", nil, bytes.NewBufferString(body))
 	SetTypicalHeaders(req, nil, nil, false)
 
-	res, _ := doRequest(req)
+	res, err := doRequest(capture.at(id), req)
+	if err != nil {
+		return GetFormResult{err: err}
+	}
 
 	html, _ := io.ReadAll(res.Body)
 	htmlString := string(html)
@@ -262,11 +296,38 @@ func GetVipForm(id uint, guestCode string) GetFormResult {
 type RegistrationResult struct {
 	success          bool
 	timestamp        time.Time
+	approvalWindow   time.Duration // how long an approved registration is valid for ("Approved for 24 hours")
 	confirmationCode string
 	vehicleId        string
 }
 
-func RegisterVehicle(formParams map[string]string, propertyId uint, residentProfileId uint, hiddenParams []string) (*RegistrationResult, error) {
+// expiry returns the time at which an approved registration lapses.
+// If the registration timestamp could not be parsed, the current time is used in its place.
+func (result *RegistrationResult) expiry() time.Time {
+	start := result.timestamp
+	if start.IsZero() {
+		start = time.Now()
+	}
+	return start.Add(result.approvalWindow)
+}
+
+// propertyTimezone is the timezone Register2Park displays registration timestamps in.
+// It can be overridden with the R2P_TIMEZONE environment variable.
+var propertyTimezone = sync.OnceValue(func() *time.Location {
+	name := os.Getenv("R2P_TIMEZONE")
+	if name == "" {
+		name = "America/Chicago"
+	}
+
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err, "timezone": name}).Warn("Failed to load property timezone, using local time")
+		return time.Local
+	}
+	return location
+})
+
+func RegisterVehicle(capture Capture, formParams map[string]string, propertyId uint, residentProfileId uint, hiddenParams []string) (*RegistrationResult, error) {
 	body := url.Values{}
 	body.Set("propertySource", "parking-snap")
 	body.Set("propertyIdSelected", strconv.FormatUint(uint64(propertyId), 10))
@@ -286,11 +347,27 @@ func RegisterVehicle(formParams map[string]string, propertyId uint, residentProf
 	req := BuildRequestWithBody("POST", "/register-vehicle-vip-process", nil, strings.NewReader(body.Encode()))
 	SetTypicalHeaders(req, nil, nil, false)
 
+	// Registering again isn't harmless, so failures are only retryable if Register2Park can't have received the request
+	var sent atomic.Bool
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			sent.Store(info.Err == nil)
+		},
+	}))
+
 	// Send the request
-	res, err := doRequest(req)
+	res, err := doRequest(capture.at(propertyId), req)
 	if err != nil {
+		if !sent.Load() {
+			return nil, fmt.Errorf("%w: %w", errUpstreamUnavailable, err)
+		}
 		return nil, err
 	}
+	if res.StatusCode == http.StatusTooManyRequests {
+		return nil, fmt.Errorf("%w: %s", errUpstreamUnavailable, res.Status)
+	} else if res.StatusCode >= http.StatusInternalServerError {
+		return nil, fmt.Errorf("unexpected response: %s", res.Status)
+	}
 
 	// Read the response body
 	html, err := io.ReadAll(res.Body)
@@ -304,7 +381,9 @@ func RegisterVehicle(formParams map[string]string, propertyId uint, residentProf
 
 	// Sanity check that a proper result was returned
 	if !result.success && !strings.Contains(htmlString, "Denied") {
-		return nil, fmt.Errorf("unexpected response: %v - %s", res, htmlString)
+		// The page is logged rather than returned, as errors are shown to users
+		log.WithField("body", htmlString).Debug("Unexpected registration response")
+		return nil, fmt.Errorf("unexpected response: %s", res.Status)
 	}
 
 	// Parse the HTML response
@@ -335,7 +414,18 @@ func RegisterVehicle(formParams map[string]string, propertyId uint, residentProf
 
 		// The confirmation code is the next sibling of the 'p' tag
 		if sibling != nil {
-			result.confirmationCode = sibling.Next().Text()
+			result.confirmationCode = strings.TrimSpace(sibling.Next().Text())
+		}
+
+		// The approval window is displayed in the heading, e.g. "Approved for 24 hours"
+		result.approvalWindow = 24 * time.Hour
+		if matches := approvalPattern.FindStringSubmatch(doc.Find("div.circle-inner > h2").First().Text()); matches != nil {
+			amount, _ := strconv.Atoi(matches[1])
+			unit := time.Hour
+			if strings.EqualFold(matches[2], "day") {
+				unit = 24 * time.Hour
+			}
+			result.approvalWindow = time.Duration(amount) * unit
 		}
 	}
 
@@ -359,7 +449,7 @@ func RegisterVehicle(formParams map[string]string, propertyId uint, residentProf
 
 		// If we found a match, parse it into a time.Time
 		if match != "" {
-			timestamp, err := time.Parse("2006-01-02 03:04 PM", match)
+			timestamp, err := time.ParseInLocation("2006-01-02 03:04 PM", match, propertyTimezone())
 
 			// Silently log the error if timestamp parsing fails
 			if err != nil {
@@ -376,7 +466,7 @@ func RegisterVehicle(formParams map[string]string, propertyId uint, residentProf
 
 // RegisterEmailConfirmation sends a request to the server to send a confirmation email regarding a vehicle's registration.
 // Example Parameters: email=xevion@xevion.dev, vehicleId=63283, propertyId=63184
-func RegisterEmailConfirmation(email string, vehicleId string, propertyId string) (bool, error) {
+func RegisterEmailConfirmation(capture Capture, email string, vehicleId string, propertyId string) (bool, error) {
 	params := map[string]string{
 		"email":          email,
 		"vehicleId":      vehicleId,
@@ -387,7 +477,7 @@ func RegisterEmailConfirmation(email string, vehicleId string, propertyId string
 	req := BuildRequestWithBody("GET", "/register-vehicle-confirmation-process", params, nil)
 	SetTypicalHeaders(req, nil, nil, false)
 
-	res, err := doRequest(req)
+	res, err := doRequest(capture, req)
 	if err != nil {
 		return false, fmt.Errorf("failed to send email confirmation request: %w", err)
 	} else if res.StatusCode != 200 {
@@ -401,5 +491,6 @@ func RegisterEmailConfirmation(email string, vehicleId string, propertyId string
 		return true, nil
 	}
 
-	return false, fmt.Errorf("unexpected response: %v - %s", res, htmlString)
+	log.WithField("body", htmlString).Debug("Unexpected email confirmation response")
+	return false, fmt.Errorf("unexpected response: %s", res.Status)
 }
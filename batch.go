@@ -0,0 +1,463 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/samber/lo"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	maxBatchSize         = 25                     // The most vehicles registered in one batch, as each is shown as an embed field
+	maxBatchAttempts     = 3                      // Transient failures are retried until a vehicle has been attempted this many times
+	batchRetryBase       = 2 * time.Second        // The delay before the first retry, doubled with every subsequent failure
+	embedFieldLimit      = 1024                   // The most characters Discord allows in an embed field's value
+	batchRequestInterval = 500 * time.Millisecond // The minimum time between registrations sent to Register2Park
+	maxBatchFileSize     = 64 * 1024              // The largest CSV attachment accepted
+)
+
+// batchColumns maps the accepted CSV header names onto the form inputs they fill.
+// Columns named after a form input's id are also accepted as they are.
+var batchColumns = map[string]string{
+	"make":          "vehicleMake",
+	"model":         "vehicleModel",
+	"plate":         "vehicleLicensePlate",
+	"license plate": "vehicleLicensePlate",
+	"apt":           "vehicleApt",
+	"apartment":     "vehicleApt",
+}
+
+// csvField returns the form input a CSV column fills, given its header name.
+func csvField(name string) string {
+	name = strings.TrimSpace(name)
+	if field, ok := batchColumns[strings.ToLower(name)]; ok {
+		return field
+	}
+	return name
+}
+
+// batchConcurrency returns how many registrations of a batch may be in flight at once.
+// This is read from BATCH_CONCURRENCY, defaulting to 2 to stay well within Register2Park's rate limits.
+func batchConcurrency() int {
+	if value, err := strconv.Atoi(os.Getenv("BATCH_CONCURRENCY")); err == nil && value > 0 {
+		return value
+	}
+	return 2
+}
+
+// BatchOutcome is the result of registering a single vehicle within a batch.
+type BatchOutcome struct {
+	Vehicle  VehicleProfile
+	Result   *RegistrationResult // The registration's result, unless it failed with an error
+	Entry    HistoryEntry        // The history entry recorded, unless it failed with an error
+	Err      error
+	Attempts int
+}
+
+// isTransient returns true if a registration failed in a way that retrying may fix, such as a rate limit.
+// Failures after the registration was sent (e.g. a timeout waiting for the response) aren't, as it may have been made.
+func isTransient(err error) bool {
+	return errors.Is(err, errUpstreamUnavailable)
+}
+
+// ParseBatchCSV reads the vehicles of a batch from CSV, with a header row naming each column.
+// Headers are matched case-insensitively against batchColumns, or a form input's id.
+func ParseBatchCSV(reader io.Reader) ([]VehicleProfile, error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.TrimLeadingSpace = true
+	csvReader.FieldsPerRecord = -1 // Trailing empty columns are often left out
+
+	header, err := csvReader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header row: %w", err)
+	}
+	columns := lo.Map(header, func(name string, _ int) string {
+		return csvField(name)
+	})
+	if !lo.Contains(columns, "vehicleLicensePlate") {
+		return nil, errors.New("no plate column found")
+	}
+
+	var vehicles []VehicleProfile
+	for {
+		row, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		values := make(map[string]string, len(row))
+		for index, value := range row {
+			if index < len(columns) && strings.TrimSpace(value) != "" {
+				values[columns[index]] = strings.TrimSpace(value)
+			}
+		}
+		if values["vehicleLicensePlate"] == "" {
+			continue
+		}
+
+		vehicles = append(vehicles, VehicleProfile{Plate: strings.ToUpper(values["vehicleLicensePlate"]), Values: values})
+	}
+
+	return vehicles, nil
+}
+
+// downloadAttachment retrieves the contents of a message attachment.
+func downloadAttachment(attachment *discordgo.MessageAttachment) ([]byte, error) {
+	if attachment.Size > maxBatchFileSize {
+		return nil, fmt.Errorf("attachment is larger than %d KB", maxBatchFileSize/1024)
+	}
+
+	response, err := (&http.Client{Timeout: 10 * time.Second}).Get(attachment.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status downloading attachment: %s", response.Status)
+	}
+	return io.ReadAll(io.LimitReader(response.Body, maxBatchFileSize))
+}
+
+// RegisterBatch registers each vehicle at the context's property, returning the outcomes in the same order.
+// Registrations run concurrently (up to batchConcurrency), spaced out by batchRequestInterval,
+// with transient failures retried using exponential backoff.
+func RegisterBatch(capture Capture, context *RegisterContext, form GetFormResult, member int, vehicles []VehicleProfile) []BatchOutcome {
+	outcomes := make([]BatchOutcome, len(vehicles))
+
+	// Every attempt, including retries, waits for the next tick so the batch as a whole is rate limited
+	ticker := time.NewTicker(batchRequestInterval)
+	defer ticker.Stop()
+
+	semaphore := make(chan struct{}, batchConcurrency())
+	var group sync.WaitGroup
+	for index, vehicle := range vehicles {
+		group.Add(1)
+		go func(index int, vehicle VehicleProfile) {
+			defer group.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			outcomes[index] = registerBatchVehicle(capture, context, form, member, vehicle, ticker.C)
+		}(index, vehicle)
+	}
+	group.Wait()
+
+	return outcomes
+}
+
+// registerBatchVehicle registers a single vehicle of a batch, retrying transient failures.
+func registerBatchVehicle(capture Capture, context *RegisterContext, form GetFormResult, member int, vehicle VehicleProfile, ticks <-chan time.Time) BatchOutcome {
+	outcome := BatchOutcome{Vehicle: vehicle}
+	log := logrus.WithFields(logrus.Fields{"plate": vehicle.Plate, "propertyId": context.propertyId})
+
+	if missing := MissingFields(form, vehicle.Values); len(missing) > 0 {
+		outcome.Err = fmt.Errorf("missing %s", strings.Join(lo.Map(missing, func(field Field, _ int) string {
+			return field.text
+		}), ", "))
+		return outcome
+	}
+
+	// Only the values the form asks for are submitted
+	formParams := make(map[string]string, len(form.fields))
+	for _, field := range form.fields {
+		formParams[field.id] = vehicle.Values[field.id]
+	}
+
+	for outcome.Attempts < maxBatchAttempts {
+		if outcome.Attempts > 0 {
+			delay := batchRetryBase * time.Duration(1<<(outcome.Attempts-1))
+			log.WithFields(logrus.Fields{"error": outcome.Err, "delay": delay}).Warn("Batch registration failed, retrying")
+			time.Sleep(delay)
+		}
+		<-ticks
+		outcome.Attempts++
+
+		outcome.Result, outcome.Err = RegisterVehicle(capture, formParams, context.propertyId, context.residentId, context.hiddenKeys)
+		if outcome.Err == nil || !isTransient(outcome.Err) {
+			break
+		}
+	}
+
+	if outcome.Err == nil {
+		if context.sharedGuild != "" {
+			RecordSharedCodeUse(context.sharedGuild, context.propertyId, member, context.sharedAction)
+		}
+		outcome.Entry = RecordRegistration(NewIdentifier(), member, 0, context.propertyId, formParams, outcome.Result)
+	}
+	return outcome
+}
+
+// BatchSummaryEmbed summarizes the outcome of every vehicle in a batch.
+func BatchSummaryEmbed(propertyId uint, outcomes []BatchOutcome) *discordgo.MessageEmbed {
+	approved := lo.CountBy(outcomes, func(outcome BatchOutcome) bool { return outcome.Err == nil && outcome.Result.success })
+	failed := lo.CountBy(outcomes, func(outcome BatchOutcome) bool { return outcome.Err != nil })
+	denied := len(outcomes) - approved - failed
+
+	color := 0x00ff00
+	if approved == 0 {
+		color = 0xff0000
+	} else if approved < len(outcomes) {
+		color = 0xffa500
+	}
+
+	return &discordgo.MessageEmbed{
+		Title:       "Batch Registration",
+		Description: fmt.Sprintf("Registered %d vehicle%s at \"%s\": %d approved, %d denied, %d failed.", len(outcomes), Plural(len(outcomes)), GetLocationName(propertyId), approved, denied, failed),
+		Color:       color,
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: GetFooterText(),
+		},
+		Fields: lo.Map(outcomes, func(outcome BatchOutcome, _ int) *discordgo.MessageEmbedField {
+			var value string
+			switch {
+			case outcome.Err != nil:
+				value = fmt.Sprintf(":warning: Failed after %d attempt%s: %s", outcome.Attempts, Plural(outcome.Attempts), outcome.Err)
+				value = TruncateRunes(value, embedFieldLimit)
+			case outcome.Result.success:
+				value = fmt.Sprintf(":white_check_mark: Approved until <t:%d:f>", outcome.Entry.ExpiresAt.Unix())
+				if outcome.Entry.ConfirmationCode != "" {
+					value += fmt.Sprintf("\nConfirmation: `%s`", outcome.Entry.ConfirmationCode)
+				}
+			default:
+				value = ":x: Denied"
+			}
+			return &discordgo.MessageEmbedField{Name: outcome.Vehicle.Describe(), Value: value, Inline: true}
+		}),
+	}
+}
+
+var RegisterBatchCommandDefinition = &discordgo.ApplicationCommand{
+	Name:        "register-batch",
+	Description: "Register several vehicles for parking at once",
+	Options: []*discordgo.ApplicationCommandOption{
+		LocationOption,
+		{
+			Type:         discordgo.ApplicationCommandOptionString,
+			Name:         "vehicles",
+			Description:  "Saved vehicles to register, as comma-separated plates, or 'all'",
+			Required:     false,
+			Autocomplete: true,
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionAttachment,
+			Name:        "csv",
+			Description: "A CSV file of vehicles, with make, model, plate & apt columns",
+			Required:    false,
+		},
+		GuestCodeOption,
+	},
+}
+
+// RegisterBatchCommandHandler registers saved vehicles, or those of a CSV attachment, at a single location.
+func RegisterBatchCommandHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+	log := logrus.WithFields(logrus.Fields{
+		"interaction": interaction.ID,
+		"user":        GetUser(interaction).ID,
+		"command":     "register-batch",
+	})
+
+	data := interaction.ApplicationCommandData()
+	options := lo.SliceToMap(data.Options, func(option *discordgo.ApplicationCommandInteractionDataOption) (string, *discordgo.ApplicationCommandInteractionDataOption) {
+		return option.Name, option
+	})
+
+	switch interaction.Type {
+
+	case discordgo.InteractionApplicationCommand:
+		locationId, parseErr := strconv.ParseUint(options[LocationOption.Name].StringValue(), 10, 64)
+		if parseErr != nil || !LocationExists(int64(locationId)) {
+			HandleError(session, interaction, parseErr, ":x: The location provided does not exist.")
+			return
+		}
+
+		userId, parseErr := strconv.Atoi(GetUser(interaction).ID)
+		if parseErr != nil {
+			HandleError(session, interaction, parseErr, "Error occurred while parsing user id")
+			return
+		}
+
+		// Collect the vehicles from both the saved vehicles chosen and the attachment
+		var vehicles []VehicleProfile
+		if option, ok := options["vehicles"]; ok {
+			saved := GetVehicles(userId)
+			if strings.EqualFold(strings.TrimSpace(option.StringValue()), "all") {
+				vehicles = append(vehicles, saved...)
+			} else {
+				for _, plate := range strings.Split(option.StringValue(), ",") {
+					profile, ok := GetVehicle(userId, strings.TrimSpace(plate))
+					if !ok {
+						HandleError(session, interaction, nil, fmt.Sprintf(":x: The vehicle `%s` is not saved.", strings.TrimSpace(plate)))
+						return
+					}
+					vehicles = append(vehicles, profile)
+				}
+			}
+		}
+
+		// Downloading the attachment and registering can take a while
+		err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Flags: discordgo.MessageFlagsEphemeral,
+			},
+		})
+		if err != nil {
+			log.WithField("error", err).Error("Failed to defer batch registration response")
+			return
+		}
+
+		if option, ok := options["csv"]; ok {
+			contents, err := downloadAttachment(data.Resolved.Attachments[option.Value.(string)])
+			if err != nil {
+				HandleError(session, interaction, err, ":x: Failed to download the CSV file.")
+				return
+			}
+			parsed, err := ParseBatchCSV(bytes.NewReader(contents))
+			if err != nil {
+				HandleError(session, interaction, err, ":x: Failed to read the CSV file.")
+				return
+			}
+			vehicles = append(vehicles, parsed...)
+		}
+
+		// The same vehicle is only registered once
+		vehicles = lo.UniqBy(vehicles, func(vehicle VehicleProfile) string {
+			return strings.ToUpper(vehicle.Plate)
+		})
+		if len(vehicles) == 0 {
+			HandleError(session, interaction, nil, ":x: No vehicles were provided. Choose saved vehicles, or attach a CSV file.")
+			return
+		} else if len(vehicles) > maxBatchSize {
+			HandleError(session, interaction, nil, fmt.Sprintf(":x: At most %d vehicles can be registered at once.", maxBatchSize))
+			return
+		}
+
+		// The guest code provided is used, otherwise the member's stored code, or one shared with them in this server
+		var code string
+		var shared bool
+		if option, ok := options[GuestCodeOption.Name]; ok {
+			code = option.StringValue()
+		} else if GetCodeRequirement(int64(locationId)) != GuestCodeNotRequired {
+			var ok bool
+			if code, ok = GetCode(int64(locationId), userId); !ok && interaction.GuildID != "" {
+				code, shared = SharedCodeFor(interaction.GuildID, uint(locationId), interaction.Member)
+			}
+		}
+
+		form := FetchForm(NewCapture(interaction), uint(locationId), code)
+		if form.requireGuestCode {
+			HandleError(session, interaction, nil, ":x: This location requires a guest code, and none was provided, stored or shared that is valid.")
+			return
+		} else if form.err != nil {
+			HandleError(session, interaction, form.err, ":x: Failed to retrieve the registration form.")
+			return
+		}
+
+		context, err := NewRegisterContext(form, uint(locationId), code)
+		if err != nil {
+			HandleError(session, interaction, err, "Error occurred while parsing resident profile identifier")
+			return
+		}
+		if shared {
+			context.shareFrom(interaction.GuildID, "batch register")
+		}
+
+		// Vehicles are registered under the member's apartment at this property, falling back to any given with the vehicle
+		apartment, hasApartment := GetApartment(uint(locationId), userId)
+		for index, vehicle := range vehicles {
+			values := make(map[string]string, len(vehicle.Values)+2)
+			for key, value := range vehicle.Values {
+				values[key] = value
+			}
+			if hasApartment {
+				values["vehicleApt"] = apartment
+			}
+			if values["vehicleLicensePlateConfirm"] == "" {
+				values["vehicleLicensePlateConfirm"] = values["vehicleLicensePlate"]
+			}
+			vehicles[index].Values = values
+		}
+
+		progress := fmt.Sprintf("Registering %d vehicle%s at \"%s\", please wait.", len(vehicles), Plural(len(vehicles)), GetLocationName(uint(locationId)))
+		if _, err := session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{Content: &progress}); err != nil {
+			log.WithField("error", err).Warn("Failed to report batch registration progress")
+		}
+
+		outcomes := RegisterBatch(NewCapture(interaction), context, form, userId, vehicles)
+		log.WithField("vehicles", len(vehicles)).Info("Completed batch registration")
+
+		empty := ""
+		_, err = session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{
+			Content: &empty,
+			Embeds:  &[]*discordgo.MessageEmbed{BatchSummaryEmbed(uint(locationId), outcomes)},
+		})
+		if err != nil {
+			log.WithField("error", err).Warn("Unable to provide batch registration summary to user")
+		}
+
+	case discordgo.InteractionApplicationCommandAutocomplete:
+		var choices []*discordgo.ApplicationCommandOptionChoice
+		if option, ok := options[LocationOption.Name]; ok && option.Focused {
+			choices = LocationChoices(interaction, option.StringValue())
+		} else if option, ok := options["vehicles"]; ok && option.Focused {
+			choices = BatchVehicleChoices(interaction, option.StringValue())
+		}
+
+		err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+			Data: &discordgo.InteractionResponseData{
+				Choices: choices,
+			},
+		})
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// BatchVehicleChoices returns autocomplete choices extending a comma-separated list of saved vehicles.
+// The plates already listed are kept, with the last entry completed from the user's saved vehicles.
+func BatchVehicleChoices(interaction *discordgo.InteractionCreate, query string) []*discordgo.ApplicationCommandOptionChoice {
+	plates := strings.Split(query, ",")
+	listed := lo.Map(plates[:len(plates)-1], func(plate string, _ int) string {
+		return strings.TrimSpace(plate)
+	})
+	prefix := strings.Join(append(listed, ""), ", ")
+
+	choices := []*discordgo.ApplicationCommandOptionChoice{}
+	if len(listed) == 0 {
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: "All saved vehicles", Value: "all"})
+	}
+	for _, choice := range VehicleChoices(interaction, strings.TrimSpace(plates[len(plates)-1])) {
+		if len(choices) >= 25 {
+			break
+		}
+		if lo.Contains(listed, choice.Value.(string)) {
+			continue
+		}
+
+		// Both the name & value of a choice are limited to 100 characters
+		value := prefix + choice.Value.(string)
+		name := prefix + choice.Name
+		if len(value) > 100 || len(name) > 100 {
+			break
+		}
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: name, Value: value})
+	}
+
+	return choices
+}
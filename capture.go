@@ -0,0 +1,499 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/samber/lo"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	captureExpiry      = 7 * 24 * time.Hour
+	captureFileEntries = 200 // Entries written to a capture file before moving on to the next one
+	captureFileLimit   = 10  // Capture files kept before the oldest are deleted
+)
+
+// Capture labels the Register2Park requests made for an interaction (or job), deciding whether they're recorded.
+type Capture struct {
+	Interaction string
+	Member      int
+	Location    uint
+}
+
+type captureKey struct{}
+
+// NewCapture labels requests made while handling an interaction.
+func NewCapture(interaction *discordgo.InteractionCreate) Capture {
+	member, _ := strconv.Atoi(GetUser(interaction).ID)
+	return Capture{Interaction: interaction.ID, Member: member}
+}
+
+// at labels requests made for a given location.
+func (capture Capture) at(location uint) Capture {
+	capture.Location = location
+	return capture
+}
+
+// label attaches the capture to a request, so the transport can find it.
+func (capture Capture) label(req *http.Request) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), captureKey{}, capture))
+}
+
+// enabled returns true if requests with this label should be recorded; either everything is (with -capture),
+// or recording was switched on for the member or location with /capture.
+func (capture Capture) enabled() bool {
+	if *captureFlag {
+		return true
+	}
+	// Without Redis (e.g. replaying or testing against a fake server), recording can't have been switched on
+	if db == nil {
+		return false
+	}
+	if capture.Member != 0 && db.SIsMember("capture_members", capture.Member).Val() {
+		return true
+	}
+	return capture.Location != 0 && db.SIsMember("capture_locations", capture.Location).Val()
+}
+
+// CaptureSink stores recorded entries, and loads those recorded for an interaction.
+// Entries can also be found or removed by what they were recorded for, such as a member forgetting their data.
+type CaptureSink interface {
+	Store(entry HAREntry) error
+	Load(interaction string) ([]HAREntry, error)
+	Find(matches func(entry HAREntry) bool) ([]HAREntry, error)
+	Remove(matches func(entry HAREntry) bool) (int, error)
+}
+
+// captureSink writes to rotating HAR files within CAPTURE_DIR if set, otherwise to Redis.
+var captureSink = sync.OnceValue(func() CaptureSink {
+	if dir := os.Getenv("CAPTURE_DIR"); dir != "" {
+		return &fileCaptureSink{dir: dir}
+	}
+	return redisCaptureSink{}
+})
+
+// RecordingTransport records Register2Park requests and their responses as HAR entries when their capture is enabled.
+type RecordingTransport struct {
+	Base http.RoundTripper // Defaults to http.DefaultTransport
+}
+
+func (transport *RecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := transport.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	// Requests without a label (e.g. session reloads) are only recorded with -capture
+	capture, _ := req.Context().Value(captureKey{}).(Capture)
+	if !capture.enabled() {
+		return base.RoundTrip(req)
+	}
+
+	started := time.Now()
+	res, err := base.RoundTrip(req)
+	if err != nil {
+		return res, err
+	}
+
+	entry, entryErr := NewHAREntry(req, res, started, time.Since(started))
+	if entryErr != nil {
+		logrus.WithFields(logrus.Fields{"error": entryErr, "interaction": capture.Interaction}).Warn("Failed to record request")
+		return res, nil
+	}
+	entry.Interaction, entry.Member, entry.Location = capture.Interaction, capture.Member, capture.Location
+
+	if err := captureSink().Store(entry); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "interaction": capture.Interaction}).Warn("Failed to store recorded request")
+	}
+	return res, nil
+}
+
+// redisCaptureSink keeps the entries recorded for each interaction in a list, for a week.
+// Entries recorded outside of an interaction (e.g. session reloads) are kept in a single, trimmed list.
+type redisCaptureSink struct{}
+
+func (redisCaptureSink) key(interaction string) string {
+	if interaction == "" {
+		return "capture:session"
+	}
+	return fmt.Sprintf("capture:%s", interaction)
+}
+
+func (sink redisCaptureSink) Store(entry HAREntry) error {
+	encoded, err := sealJSON(entry)
+	if err != nil {
+		return err
+	}
+
+	key := sink.key(entry.Interaction)
+	if err := db.RPush(key, encoded).Err(); err != nil {
+		return err
+	}
+	if entry.Interaction == "" {
+		db.LTrim(key, -captureFileEntries, -1)
+	}
+	return db.Expire(key, captureExpiry).Err()
+}
+
+func (sink redisCaptureSink) Load(interaction string) ([]HAREntry, error) {
+	entries := make([]HAREntry, 0)
+	for _, raw := range db.LRange(sink.key(interaction), 0, -1).Val() {
+		var entry HAREntry
+		if err := unsealJSON(raw, &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (sink redisCaptureSink) Find(matches func(entry HAREntry) bool) ([]HAREntry, error) {
+	entries := make([]HAREntry, 0)
+	for _, key := range scanKeys("capture:*") {
+		for _, raw := range db.LRange(key, 0, -1).Val() {
+			var entry HAREntry
+			if err := unsealJSON(raw, &entry); err != nil {
+				return nil, err
+			}
+			if matches(entry) {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	return entries, nil
+}
+
+func (sink redisCaptureSink) Remove(matches func(entry HAREntry) bool) (int, error) {
+	removed := 0
+	for _, key := range scanKeys("capture:*") {
+		for _, raw := range db.LRange(key, 0, -1).Val() {
+			var entry HAREntry
+			if err := unsealJSON(raw, &entry); err != nil {
+				return removed, err
+			}
+			if matches(entry) {
+				removed += int(db.LRem(key, 0, raw).Val())
+			}
+		}
+	}
+	return removed, nil
+}
+
+// fileCaptureSink writes entries to HAR files within a directory, starting a new file every captureFileEntries
+// entries and deleting the oldest beyond captureFileLimit. Each file is a complete archive, rewritten as it grows.
+type fileCaptureSink struct {
+	dir     string
+	lock    sync.Mutex
+	path    string
+	entries []HAREntry
+}
+
+func (sink *fileCaptureSink) Store(entry HAREntry) error {
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+
+	if sink.path == "" || len(sink.entries) >= captureFileEntries {
+		if err := os.MkdirAll(sink.dir, 0o700); err != nil {
+			return err
+		}
+		sink.path = filepath.Join(sink.dir, fmt.Sprintf("capture-%s.har", time.Now().Format("20060102-150405.000")))
+		sink.entries = nil
+		sink.prune()
+	}
+	sink.entries = append(sink.entries, entry)
+
+	var buffer bytes.Buffer
+	if err := WriteHAR(&buffer, sink.entries...); err != nil {
+		return err
+	}
+	return os.WriteFile(sink.path, buffer.Bytes(), 0o600)
+}
+
+// prune deletes the oldest capture files, leaving room for the current one.
+func (sink *fileCaptureSink) prune() {
+	paths, _ := filepath.Glob(filepath.Join(sink.dir, "capture-*.har"))
+	slices.Sort(paths) // Names are timestamped, so they sort oldest first
+	for len(paths) >= captureFileLimit {
+		if err := os.Remove(paths[0]); err != nil {
+			logrus.WithFields(logrus.Fields{"error": err, "path": paths[0]}).Warn("Failed to delete old capture file")
+		}
+		paths = paths[1:]
+	}
+}
+
+func (sink *fileCaptureSink) Load(interaction string) ([]HAREntry, error) {
+	return sink.Find(func(entry HAREntry) bool {
+		return entry.Interaction == interaction
+	})
+}
+
+func (sink *fileCaptureSink) Find(matches func(entry HAREntry) bool) ([]HAREntry, error) {
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+
+	paths, err := filepath.Glob(filepath.Join(sink.dir, "capture-*.har"))
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(paths)
+
+	entries := make([]HAREntry, 0)
+	for _, path := range paths {
+		archive, err := ReadHARFile(path)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, lo.Filter(archive.Log.Entries, func(entry HAREntry, _ int) bool {
+			return matches(entry)
+		})...)
+	}
+	return entries, nil
+}
+
+// Remove rewrites every capture file holding matching entries without them.
+func (sink *fileCaptureSink) Remove(matches func(entry HAREntry) bool) (int, error) {
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+
+	paths, err := filepath.Glob(filepath.Join(sink.dir, "capture-*.har"))
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, path := range paths {
+		archive, err := ReadHARFile(path)
+		if err != nil {
+			return removed, err
+		}
+		kept := lo.Reject(archive.Log.Entries, func(entry HAREntry, _ int) bool {
+			return matches(entry)
+		})
+		if len(kept) == len(archive.Log.Entries) {
+			continue
+		}
+
+		var buffer bytes.Buffer
+		if err := WriteHAR(&buffer, kept...); err != nil {
+			return removed, err
+		}
+		if err := os.WriteFile(path, buffer.Bytes(), 0o600); err != nil {
+			return removed, err
+		}
+		removed += len(archive.Log.Entries) - len(kept)
+
+		// The current file is rewritten from memory as it grows
+		if path == sink.path {
+			sink.entries = kept
+		}
+	}
+	return removed, nil
+}
+
+var administratorPermission int64 = discordgo.PermissionAdministrator
+
+var CaptureCommandDefinition = &discordgo.ApplicationCommand{
+	Name:                     "capture",
+	Description:              "Record Register2Park traffic for troubleshooting",
+	DefaultMemberPermissions: &administratorPermission,
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "user",
+			Description: "Record traffic for a member's registrations",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionUser,
+					Name:        "member",
+					Description: "The member to record traffic for",
+					Required:    true,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Name:        "enabled",
+					Description: "Whether to record their traffic",
+					Required:    true,
+				},
+			},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "location",
+			Description: "Record traffic for registrations at a complex",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:         discordgo.ApplicationCommandOptionString,
+					Name:         "location",
+					Description:  "The complex to record traffic for",
+					Required:     true,
+					Autocomplete: true,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Name:        "enabled",
+					Description: "Whether to record its traffic",
+					Required:    true,
+				},
+			},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "get",
+			Description: "Download the traffic recorded for an interaction, as a HAR file",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "interaction",
+					Description: "The interaction ID, shown at the bottom of error messages",
+					Required:    true,
+				},
+			},
+		},
+	},
+}
+
+func CaptureCommandHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+	log := logrus.WithFields(logrus.Fields{
+		"interaction": interaction.ID,
+		"user":        GetUser(interaction).ID,
+		"command":     "capture",
+	})
+
+	if interaction.Member == nil || interaction.Member.Permissions&discordgo.PermissionAdministrator == 0 {
+		HandleError(session, interaction, nil, ":x: Only server administrators can record traffic.")
+		return
+	}
+
+	data := interaction.ApplicationCommandData()
+	subcommand := data.Options[0]
+
+	switch interaction.Type {
+
+	case discordgo.InteractionApplicationCommand:
+		options := lo.SliceToMap(subcommand.Options, func(option *discordgo.ApplicationCommandInteractionDataOption) (string, *discordgo.ApplicationCommandInteractionDataOption) {
+			return option.Name, option
+		})
+
+		var content string
+		switch subcommand.Name {
+		case "user":
+			member := options["member"].UserValue(nil)
+			if options["enabled"].BoolValue() {
+				db.SAdd("capture_members", member.ID)
+				content = fmt.Sprintf("Recording traffic for <@%s>'s registrations.", member.ID)
+			} else {
+				db.SRem("capture_members", member.ID)
+				content = fmt.Sprintf("Stopped recording traffic for <@%s>'s registrations.", member.ID)
+			}
+			log.WithField("member", member.ID).Info("Updated traffic capture")
+
+		case "location":
+			propertyId, parseErr := strconv.ParseUint(options["location"].StringValue(), 10, 64)
+			if parseErr != nil || !LocationExists(int64(propertyId)) {
+				HandleError(session, interaction, parseErr, ":x: The location provided does not exist.")
+				return
+			}
+
+			if options["enabled"].BoolValue() {
+				db.SAdd("capture_locations", propertyId)
+				content = fmt.Sprintf("Recording traffic for registrations at \"%s\".", GetLocationName(uint(propertyId)))
+			} else {
+				db.SRem("capture_locations", propertyId)
+				content = fmt.Sprintf("Stopped recording traffic for registrations at \"%s\".", GetLocationName(uint(propertyId)))
+			}
+			log.WithField("location", propertyId).Info("Updated traffic capture")
+
+		case "get":
+			CaptureGetHandler(session, interaction, options["interaction"].StringValue())
+			return
+		}
+
+		err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: content,
+				Flags:   discordgo.MessageFlagsEphemeral,
+			},
+		})
+		if err != nil {
+			log.WithField("error", err).Error("Failed to respond to capture command")
+		}
+
+	case discordgo.InteractionApplicationCommandAutocomplete:
+		focusedOption, _ := lo.Find(subcommand.Options, func(option *discordgo.ApplicationCommandInteractionDataOption) bool {
+			return option.Focused
+		})
+
+		var choices []*discordgo.ApplicationCommandOptionChoice
+		if focusedOption != nil && focusedOption.Name == "location" {
+			choices = LocationChoices(interaction, focusedOption.StringValue())
+		}
+
+		err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+			Data: &discordgo.InteractionResponseData{
+				Choices: choices,
+			},
+		})
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// CaptureGetHandler responds with the traffic recorded for an interaction as a HAR file.
+func CaptureGetHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate, target string) {
+	// Capture files may need to be read in full
+	err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Flags: discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		logrus.WithField("error", err).Error("Failed to defer capture download")
+		return
+	}
+
+	entries, err := captureSink().Load(target)
+	if err != nil {
+		HandleError(session, interaction, err, "Failed to load the recorded traffic")
+		return
+	}
+
+	if len(entries) == 0 {
+		content := fmt.Sprintf("No traffic was recorded for interaction `%s`. Recording must be switched on before the interaction.", target)
+		_, err = session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{Content: &content})
+	} else {
+		var buffer bytes.Buffer
+		if err := WriteHAR(&buffer, entries...); err != nil {
+			HandleError(session, interaction, err, "Failed to encode the recorded traffic")
+			return
+		}
+
+		content := fmt.Sprintf("%d request%s recorded for interaction `%s`.", len(entries), Plural(len(entries)), target)
+		_, err = session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{
+			Content: &content,
+			Files: []*discordgo.File{
+				{
+					Name:        fmt.Sprintf("capture-%s.har", target),
+					ContentType: "application/json",
+					Reader:      &buffer,
+				},
+			},
+		})
+	}
+	if err != nil {
+		logrus.WithField("error", err).Error("Failed to respond with recorded traffic")
+	}
+}
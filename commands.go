@@ -3,11 +3,12 @@ package main
 import (
 	"fmt"
 	"regexp"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
-	"github.com/davecgh/go-spew/spew"
 	"github.com/samber/lo"
 	"github.com/sirupsen/logrus"
 	"github.com/zekroTJA/timedmap"
@@ -18,22 +19,56 @@ var SubmissionContexts = timedmap.New(5 * time.Minute)
 
 var codePattern = regexp.MustCompile(`^[a-zA-Z0-9]{4,12}$`)
 
+var codeLocationOption = &discordgo.ApplicationCommandOption{
+	Type:         discordgo.ApplicationCommandOptionString,
+	Name:         "location",
+	Description:  "The complex the code is for",
+	Required:     true,
+	Autocomplete: true,
+}
+
 var CodeCommandDefinition = &discordgo.ApplicationCommand{
 	Name:        "code",
-	Description: "Set the guest code for a given location",
+	Description: "Manage your stored guest codes",
 	Options: []*discordgo.ApplicationCommandOption{
 		{
-			Type:         discordgo.ApplicationCommandOptionString,
-			Name:         "location",
-			Description:  "The complex to set the code for",
-			Required:     true,
-			Autocomplete: true,
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "set",
+			Description: "Set the guest code for a given location",
+			Options: []*discordgo.ApplicationCommandOption{
+				codeLocationOption,
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "code",
+					Description: "The new code to set",
+					Required:    true,
+				},
+			},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "list",
+			Description: "List your stored guest codes",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Name:        "reveal",
+					Description: "Show the codes in full instead of masking them",
+					Required:    false,
+				},
+			},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "remove",
+			Description: "Remove the stored guest code for a given location",
+			Options:     []*discordgo.ApplicationCommandOption{codeLocationOption},
 		},
 		{
-			Type:        discordgo.ApplicationCommandOptionString,
-			Name:        "code",
-			Description: "The new code to set",
-			Required:    true,
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "verify",
+			Description: "Check that the stored guest code for a given location still works",
+			Options:     []*discordgo.ApplicationCommandOption{codeLocationOption},
 		},
 	},
 }
@@ -41,89 +76,94 @@ var CodeCommandDefinition = &discordgo.ApplicationCommand{
 func CodeCommandHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
 	log := logrus.WithFields(logrus.Fields{
 		"interaction": interaction.ID,
-		"user":        interaction.Member.User.ID,
+		"user":        GetUser(interaction).ID,
 		"command":     "code",
 	})
 
+	data := interaction.ApplicationCommandData()
+	subcommand := data.Options[0]
+
 	switch interaction.Type {
 
 	case discordgo.InteractionApplicationCommand:
-		data := interaction.ApplicationCommandData()
+		userId, parseErr := strconv.Atoi(GetUser(interaction).ID)
+		if parseErr != nil {
+			HandleError(session, interaction, parseErr, "Error occurred while parsing user id")
+			return
+		}
 
-		locationId, _ := strconv.Atoi(data.Options[0].StringValue())
-		code := data.Options[1].StringValue()
-		userId, _ := strconv.Atoi(interaction.Member.User.ID)
+		if subcommand.Name == "list" {
+			reveal := len(subcommand.Options) > 0 && subcommand.Options[0].BoolValue()
+			respondToCode(session, interaction, DescribeCodes(userId, GetCodes(userId), reveal), true)
+			return
+		}
 
-		// Validate that the location exists
+		// Every other subcommand operates on a single location
+		locationId, _ := strconv.Atoi(subcommand.Options[0].StringValue())
 		if !LocationExists(int64(locationId)) {
 			HandleError(session, interaction, nil, "The location provided does not exist.")
 			return
 		}
+		locationName := GetLocationName(uint(locationId))
 
-		// Validate that the code has no invalid characters
-		if !codePattern.MatchString(code) {
-			HandleError(session, interaction, nil, "The code provided contains invalid characters.")
-			return
-		}
+		switch subcommand.Name {
+		case "set":
+			submitCode(session, interaction, int64(locationId), userId, subcommand.Options[1].StringValue())
 
-		alreadySet := StoreCode(code, int64(locationId), userId)
-		responseText := "Your guest code at \"%s\" has been set."
-		if alreadySet {
-			responseText = "Your guest code at \"%s\" has been updated."
-		}
+		case "remove":
+			if _, ok := GetCode(int64(locationId), userId); !ok {
+				HandleError(session, interaction, nil, fmt.Sprintf(":x: You have no guest code stored for \"%s\".", locationName))
+				return
+			}
 
-		location := cachedLocationsMap[uint(locationId)]
+			RemoveCode(int64(locationId), userId)
+			log.WithField("location", locationId).Debug("Removed stored code")
+			respondToCode(session, interaction, fmt.Sprintf("Your guest code at \"%s\" has been removed.", locationName), true)
 
-		session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
-			Type: discordgo.InteractionResponseChannelMessageWithSource,
-			Data: &discordgo.InteractionResponseData{
-				Embeds: []*discordgo.MessageEmbed{
-					{
-						Footer: &discordgo.MessageEmbedFooter{
-							Text: GetFooterText(),
-						},
-						Description: fmt.Sprintf(responseText, location.name),
-						Fields:      []*discordgo.MessageEmbedField{},
-					},
-				},
-				AllowedMentions: &discordgo.MessageAllowedMentions{},
-			},
-		})
+		case "verify":
+			code, ok := GetCode(int64(locationId), userId)
+			if !ok {
+				HandleError(session, interaction, nil, fmt.Sprintf(":x: You have no guest code stored for \"%s\".", locationName))
+				return
+			}
 
-	case discordgo.InteractionApplicationCommandAutocomplete:
-		data := interaction.ApplicationCommandData()
-		var choices []*discordgo.ApplicationCommandOptionChoice
+			// Checking the code can take a moment, so defer the response
+			err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+				Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+				Data: &discordgo.InteractionResponseData{Flags: discordgo.MessageFlagsEphemeral},
+			})
+			if err != nil {
+				log.WithField("error", err).Error("Failed to defer code verification")
+				return
+			}
 
-		LocationOption := data.Options[0]
-
-		switch {
-		case LocationOption.Focused:
-			// Seed value is based on the user ID + a 15 minute interval)
-			userId, _ := strconv.Atoi(interaction.Member.User.ID)
-			seedValue := int64(userId) + (time.Now().Unix() / 15 * 60)
-			locations := FilterLocations(GetLocations(), data.Options[0].StringValue(), 25, seedValue)
-
-			// Convert the locations to choices
-			choices = make([]*discordgo.ApplicationCommandOptionChoice, len(locations))
-			for i, location := range locations {
-				choices[i] = &discordgo.ApplicationCommandOptionChoice{
-					Name:  location.name,
-					Value: strconv.Itoa(int(location.id)),
-				}
+			var content string
+			switch verified, accepted := ValidateCode(NewCapture(interaction), int64(locationId), code); {
+			case !accepted:
+				SetCodeStatus(int64(locationId), userId, CodeInvalid)
+				content = fmt.Sprintf(":x: Your guest code at \"%s\" is no longer valid. Set a new one with `/code set`.", locationName)
+			case !verified:
+				content = fmt.Sprintf(":warning: Your guest code at \"%s\" could not be checked right now. Please try again later.", locationName)
+			default:
+				SetCodeStatus(int64(locationId), userId, CodeVerified)
+				content = fmt.Sprintf(":white_check_mark: Your guest code at \"%s\" is valid.", locationName)
 			}
-		default:
-			// An option was focused, but it does not have a handler.
-			var focusedOption *discordgo.ApplicationCommandInteractionDataOption
-			focusedIndex := 0
-			for i, option := range data.Options {
-				if option.Focused {
-					focusedOption = option
-					focusedIndex = i
-					break
-				}
+
+			_, err = session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{Content: &content})
+			if err != nil {
+				log.WithField("error", err).Error("Failed to provide code verification result")
 			}
-			log.WithFields(logrus.Fields{"focusedIndex": focusedIndex, "focusedOption": focusedOption.Name, "focusedOption.value": focusedOption.Value}).Warn("Unhandled autocomplete option")
-			return
+		}
+
+	case discordgo.InteractionApplicationCommandAutocomplete:
+		var choices []*discordgo.ApplicationCommandOptionChoice
+
+		focusedOption, _ := lo.Find(subcommand.Options, func(option *discordgo.ApplicationCommandInteractionDataOption) bool {
+			return option.Focused
+		})
+
+		if focusedOption != nil && focusedOption.Name == codeLocationOption.Name {
+			choices = LocationChoices(interaction, focusedOption.StringValue())
 		}
 
 		err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
@@ -138,6 +178,179 @@ func CodeCommandHandler(session *discordgo.Session, interaction *discordgo.Inter
 	}
 }
 
+// submitCode validates a new guest code with Register2Park and stores it if accepted, reporting the outcome.
+func submitCode(session *discordgo.Session, interaction *discordgo.InteractionCreate, location int64, member int, code string) {
+	// Validate that the code has no invalid characters
+	if !codePattern.MatchString(code) {
+		HandleError(session, interaction, nil, "The code provided contains invalid characters.")
+		return
+	}
+
+	// Checking the code can take a moment, so defer the response
+	err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+	if err != nil {
+		logrus.WithField("error", err).Error("Failed to defer code update")
+		return
+	}
+
+	locationName := GetLocationName(uint(location))
+	var content string
+	switch verified, accepted := ValidateCode(NewCapture(interaction), location, code); {
+	case !accepted:
+		content = fmt.Sprintf(":x: The guest code provided was not accepted at \"%s\", so it has not been stored.", locationName)
+	case !verified:
+		StoreCode(code, location, member, CodeUnverified)
+		content = fmt.Sprintf(":warning: Register2Park could not be reached, so your guest code at \"%s\" has been stored unverified. Check it later with `/code verify`.", locationName)
+	default:
+		responseText := ":white_check_mark: Your guest code at \"%s\" was accepted and has been set."
+		if StoreCode(code, location, member, CodeVerified) {
+			responseText = ":white_check_mark: Your guest code at \"%s\" was accepted and has been updated."
+		}
+		content = fmt.Sprintf(responseText, locationName)
+	}
+
+	_, err = session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{
+		Embeds: &[]*discordgo.MessageEmbed{CodeEmbed(content)},
+	})
+	if err != nil {
+		logrus.WithField("error", err).Error("Failed to provide code update result")
+	}
+}
+
+// respondToCode responds to a /code command with a simple embed.
+func respondToCode(session *discordgo.Session, interaction *discordgo.InteractionCreate, description string, ephemeral bool) {
+	var flags discordgo.MessageFlags
+	if ephemeral {
+		flags = discordgo.MessageFlagsEphemeral
+	}
+
+	err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Embeds:          []*discordgo.MessageEmbed{CodeEmbed(description)},
+			Flags:           flags,
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		},
+	})
+	if err != nil {
+		logrus.WithField("error", err).Error("Failed to respond to code command")
+	}
+}
+
+// CodeEmbed builds the embed used to respond to /code commands.
+func CodeEmbed(description string) *discordgo.MessageEmbed {
+	return &discordgo.MessageEmbed{
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: GetFooterText(),
+		},
+		Description: description,
+		Fields:      []*discordgo.MessageEmbedField{},
+	}
+}
+
+// ValidateCode checks a guest code against Register2Park without registering.
+// Verified is false if Register2Park could not be reached, in which case the code is assumed to be accepted.
+// The location's guest code requirement and form schema are cached from the result.
+func ValidateCode(capture Capture, location int64, code string) (verified bool, accepted bool) {
+	form := GetVipForm(capture, uint(location), code)
+	if form.requireGuestCode {
+		// Only locations protected by a guest code can reject one
+		SetCodeRequirement(location, true)
+		return true, false
+	} else if form.err != nil {
+		logrus.WithFields(logrus.Fields{"error": form.err, "location": location}).Warn("Unable to validate guest code")
+		return false, true
+	}
+
+	StoreFormSchema(uint(location), NewFormSchema(form, true))
+	return true, true
+}
+
+// MaskCode hides all but the last two characters of a guest code.
+func MaskCode(code string) string {
+	if len(code) <= 2 {
+		return strings.Repeat("•", len(code))
+	}
+	return strings.Repeat("•", len(code)-2) + code[len(code)-2:]
+}
+
+// DescribeCodes lists a member's stored guest codes by location name, masked unless revealed.
+func DescribeCodes(member int, codes map[uint]string, reveal bool) string {
+	if len(codes) == 0 {
+		return "You have no guest codes stored. Set one with `/code set`."
+	}
+
+	lines := lo.MapToSlice(codes, func(location uint, code string) string {
+		if !reveal {
+			code = MaskCode(code)
+		}
+		line := fmt.Sprintf("**%s**: `%s`", GetLocationName(location), code)
+		if status := GetCodeStatus(int64(location), member); status != CodeVerified {
+			line += fmt.Sprintf(" (%s)", status)
+		}
+		return line
+	})
+	slices.Sort(lines)
+
+	return strings.Join(lines, "\n")
+}
+
+// ForgetInvalidCode removes a stored guest code that stopped working and lets the member know by DM.
+func ForgetInvalidCode(session *discordgo.Session, location int64, member int) {
+	RemoveCode(location, member)
+
+	err := SendDirectMessage(session, strconv.Itoa(member), &discordgo.MessageSend{
+		Content: fmt.Sprintf(":warning: Your stored guest code at \"%s\" stopped working and has been removed. Set a new one with `/code set`.",
+			GetLocationName(uint(location))),
+	})
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "user": member}).Warn("Failed to notify user of removed code")
+	}
+}
+
+// LocationChoices returns the autocomplete choices for a location option given the user's query.
+func LocationChoices(interaction *discordgo.InteractionCreate, query string) []*discordgo.ApplicationCommandOptionChoice {
+	// Seed value is based on the user ID + a 15 minute interval)
+	userId, _ := strconv.Atoi(GetUser(interaction).ID)
+	seedValue := int64(userId) + (time.Now().Unix() / 15 * 60)
+	locations := FilterLocations(GetLocations(), query, 25, seedValue)
+
+	// Convert the locations to choices
+	choices := make([]*discordgo.ApplicationCommandOptionChoice, len(locations))
+	for i, location := range locations {
+		choices[i] = &discordgo.ApplicationCommandOptionChoice{
+			Name:  location.name,
+			Value: strconv.Itoa(int(location.id)),
+		}
+	}
+
+	return choices
+}
+
+// VehicleChoices returns the autocomplete choices for the user's saved vehicles given their query.
+func VehicleChoices(interaction *discordgo.InteractionCreate, query string) []*discordgo.ApplicationCommandOptionChoice {
+	userId, _ := strconv.Atoi(GetUser(interaction).ID)
+	query = strings.ToLower(query)
+
+	choices := make([]*discordgo.ApplicationCommandOptionChoice, 0, 25)
+	for _, profile := range GetVehicles(userId) {
+		if len(choices) >= 25 {
+			break
+		}
+
+		if strings.Contains(strings.ToLower(profile.Describe()), query) {
+			choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+				Name:  profile.Describe(),
+				Value: profile.Plate,
+			})
+		}
+	}
+
+	return choices
+}
+
 var (
 	LocationOption = &discordgo.ApplicationCommandOption{
 		Type:         discordgo.ApplicationCommandOptionString,
@@ -152,12 +365,27 @@ var (
 		Description: "The guest code, if required",
 		Required:    false,
 	}
+	VehicleOption = &discordgo.ApplicationCommandOption{
+		Type:         discordgo.ApplicationCommandOptionString,
+		Name:         "vehicle",
+		Description:  "A saved vehicle to fill the form with",
+		Required:     false,
+		Autocomplete: true,
+	}
+	OnBehalfOption = &discordgo.ApplicationCommandOption{
+		Type:        discordgo.ApplicationCommandOptionUser,
+		Name:        "for",
+		Description: "The member to register the vehicle for, if not yourself",
+		Required:    false,
+	}
 	RegisterCommandDefinition = &discordgo.ApplicationCommand{
 		Name:        "register",
 		Description: "Register a vehicle for parking",
 		Options: []*discordgo.ApplicationCommandOption{
 			LocationOption,
 			GuestCodeOption,
+			VehicleOption,
+			OnBehalfOption,
 		},
 	}
 )
@@ -180,14 +408,16 @@ func RegisterCommandHandler(session *discordgo.Session, interaction *discordgo.I
 			return
 		}
 
-		var useStoredCode bool
+		var useStoredCode, useSharedCode bool
 		var code string
 
 		// Check if a guest code was provided (and set it)
-		_, guestCodeProvided := lo.Find(data.Options, func(option *discordgo.ApplicationCommandInteractionDataOption) bool {
-			code = option.StringValue()
+		guestCodeOption, guestCodeProvided := lo.Find(data.Options, func(option *discordgo.ApplicationCommandInteractionDataOption) bool {
 			return option.Name == GuestCodeOption.Name
 		})
+		if guestCodeProvided {
+			code = guestCodeOption.StringValue()
+		}
 
 		userId, parseErr := strconv.Atoi(interaction.Member.User.ID)
 		if parseErr != nil {
@@ -195,23 +425,61 @@ func RegisterCommandHandler(session *discordgo.Session, interaction *discordgo.I
 			return
 		}
 
+		// Check if the vehicle is being registered for another member, and whether that's allowed
+		var onBehalfOf int
+		onBehalfOption, onBehalfProvided := lo.Find(data.Options, func(option *discordgo.ApplicationCommandInteractionDataOption) bool {
+			return option.Name == OnBehalfOption.Name
+		})
+		if onBehalfProvided {
+			target := onBehalfOption.UserValue(nil)
+			if resolved, ok := data.Resolved.Users[target.ID]; ok {
+				target = resolved
+			}
+			if target.Bot {
+				HandleError(session, interaction, nil, ":x: Vehicles can't be registered for bots.")
+				return
+			}
+			if target.ID != interaction.Member.User.ID {
+				if !CanRegisterOnBehalf(interaction) {
+					HandleError(session, interaction, nil, ":x: You aren't allowed to register vehicles on behalf of other members in this server.")
+					return
+				}
+				if onBehalfOf, parseErr = strconv.Atoi(target.ID); parseErr != nil {
+					HandleError(session, interaction, parseErr, "Error occurred while parsing user id")
+					return
+				}
+			}
+		}
+
 		// Check if a guest code is required for this location
 		guestCodeCondition := GetCodeRequirement(int64(locationId))
 
 		// TODO: Add case for when guest code is provided but not required
 
-		// Circumstance under which error is certain
-		if !guestCodeProvided && guestCodeCondition == GuestCodeNotRequired {
-			// A guest code could be stored, so check for it.
-			log.WithField("location", locationId).Debug("No guest code provided for location, but one is not required. Checking for stored code.")
-			code, ok := GetCode(int64(locationId), int(userId))
+		// A guest code could be stored, so check for it.
+		if !guestCodeProvided && guestCodeCondition != GuestCodeNotRequired {
+			log.WithField("location", locationId).Debug("No guest code provided for location, but one may be required. Checking for stored code.")
+			storedCode, ok := GetCode(int64(locationId), int(userId))
+
+			// Fall back to a code shared with the member in this guild
+			if !ok && interaction.GuildID != "" {
+				if sharedCode, shared := SharedCodeFor(interaction.GuildID, uint(locationId), interaction.Member); shared {
+					log.WithField("location", locationId).Debug("Using shared code for location")
+					code = sharedCode
+					guestCodeProvided = true
+					useSharedCode = true
+				}
+			}
 
 			if !ok {
-				// No code was stored, error out.
-				HandleError(session, interaction, nil, ":x: This location requires a guest code.")
-				return
+				// No code was stored or shared, error out if we know one is required.
+				if !useSharedCode && guestCodeCondition == GuestCodeRequired {
+					HandleError(session, interaction, nil, ":x: This location requires a guest code.")
+					return
+				}
 			} else {
 				// Code available, use it.
+				code = storedCode
 				log.WithFields(logrus.Fields{
 					"locationId": locationId,
 					"code":       code,
@@ -224,21 +492,26 @@ func RegisterCommandHandler(session *discordgo.Session, interaction *discordgo.I
 		// Get the form for the location
 		var form GetFormResult
 		if guestCodeProvided {
-			form = GetVipForm(uint(locationId), code)
+			form = GetVipForm(NewCapture(interaction), uint(locationId), code)
 
 			// requireGuestCode being returned for a VIP form indicates an invalid code.
 			if form.requireGuestCode {
 				// Handling is the same for both cases, but the message differs & the code is removed if it was stored.
 				if useStoredCode {
 					HandleError(session, interaction, nil, ":x: This location requires a guest code and the one stored was not valid (and subsequently deleted).")
-					RemoveCode(int64(locationId), int(userId))
+					ForgetInvalidCode(session, int64(locationId), userId)
+				} else if useSharedCode {
+					HandleError(session, interaction, nil, ":x: This location requires a guest code and the one shared in this server is not valid. Ask the member who shared it to update it.")
 				} else {
 					HandleError(session, interaction, nil, ":x: This location requires a guest code and the one provided was not valid.")
 				}
 				return
+			} else if useStoredCode && form.err == nil {
+				// An unverified code has now been accepted
+				SetCodeStatus(int64(locationId), userId, CodeVerified)
 			}
 		} else {
-			form = GetForm(uint(locationId))
+			form = GetForm(NewCapture(interaction), uint(locationId))
 
 			if form.requireGuestCode {
 				// The code ended up being required, so we mark it as such.
@@ -253,63 +526,36 @@ func RegisterCommandHandler(session *discordgo.Session, interaction *discordgo.I
 			}
 		}
 
-		// Convert the form into message components for a modal presented to the user
-		registrationFormComponents := FormToModalComponents(form)
-
-		// The ID of the original interaction is used as the identifier for the registration context (uint64)
-		registerIdentifier, parseErr := strconv.ParseUint(interaction.ID, 10, 64)
-		if parseErr != nil {
-			HandleError(session, interaction, parseErr, "Error occurred while parsing interaction message identifier")
+		// Load the saved vehicle profile, if one was chosen
+		var profile VehicleProfile
+		vehicleOption, vehicleProvided := lo.Find(data.Options, func(option *discordgo.ApplicationCommandInteractionDataOption) bool {
+			return option.Name == VehicleOption.Name
+		})
+		if vehicleProvided {
+			var ok bool
+			profile, ok = GetVehicle(userId, vehicleOption.StringValue())
+			if !ok {
+				HandleError(session, interaction, nil, ":x: The vehicle provided is not saved.")
+				return
+			}
 		}
 
-		// Parse resident profile ID
-		residentProfileId, parseErr := strconv.ParseUint(form.residentProfileId, 10, 64)
-		if parseErr != nil {
-			HandleError(session, interaction, parseErr, "Error occurred while parsing resident profile identifier")
+		context, err := NewRegisterContext(form, uint(locationId), code)
+		if err != nil {
+			HandleError(session, interaction, err, "Error occurred while parsing resident profile identifier")
+			return
 		}
-
-		// Log the registration context at debug
-		log.WithFields(logrus.Fields{
-			"registerIdentifier": registerIdentifier,
-			"propertyId":         locationId,
-			"residentId":         form.residentProfileId,
-		})
-
-		// Store the registration context for later use
-		SubmissionContexts.Set(registerIdentifier, &RegisterContext{
-			hiddenKeys: form.hiddenInputs,
-			propertyId: uint(locationId),
-			requiredFormKeys: lo.Map(form.fields, func(field Field, _ int) string {
-				return field.id
-			}),
-			residentId: uint(residentProfileId),
-		}, time.Hour)
-
-		registrationFormComponents = append(registrationFormComponents, discordgo.ActionsRow{
-			Components: []discordgo.MessageComponent{
-				discordgo.TextInput{
-					CustomID:  "email",
-					Label:     "Email Address (for confirmation)",
-					Style:     discordgo.TextInputShort,
-					Required:  false,
-					MinLength: 1,
-				},
-			},
-		})
-
-		response := discordgo.InteractionResponse{
-			Type: discordgo.InteractionResponseModal,
-			Data: &discordgo.InteractionResponseData{
-				CustomID:   "register:" + interaction.ID,
-				Title:      "Vehicle Registration",
-				Components: registrationFormComponents,
-			},
+		if useSharedCode {
+			context.shareFrom(interaction.GuildID, "register")
 		}
+		context.onBehalfOf = onBehalfOf
 
-		err := session.InteractionRespond(interaction.Interaction, &response)
-		if err != nil {
-			log.WithField("dump", spew.Sdump(response)).Error(err)
+		// Profiles saved before apartments were kept per property may hold one from another property
+		values := profile.Values
+		if vehicleProvided {
+			values = valuesAt(profile.Values, nil, uint(locationId), userId)
 		}
+		PresentRegistration(session, interaction, context, form, values, profile.Email)
 
 	// Autocomplete is used to provide the user with a list of locations to choose from
 	case discordgo.InteractionApplicationCommandAutocomplete:
@@ -323,19 +569,10 @@ func RegisterCommandHandler(session *discordgo.Session, interaction *discordgo.I
 
 		switch focusedOption.Name {
 		case LocationOption.Name:
-			// Seed value is based on the user ID + a 15 minute interval)
-			userId, _ := strconv.Atoi(interaction.Member.User.ID)
-			seedValue := int64(userId) + (time.Now().Unix() / 15 * 60)
-			locations := FilterLocations(GetLocations(), data.Options[0].StringValue(), 25, seedValue)
-
-			// Convert the locations to choices
-			choices = make([]*discordgo.ApplicationCommandOptionChoice, len(locations))
-			for i, location := range locations {
-				choices[i] = &discordgo.ApplicationCommandOptionChoice{
-					Name:  location.name,
-					Value: strconv.Itoa(int(location.id)),
-				}
-			}
+			choices = LocationChoices(interaction, data.Options[0].StringValue())
+
+		case VehicleOption.Name:
+			choices = VehicleChoices(interaction, focusedOption.StringValue())
 
 		default:
 			// An option was focused, but it does not have a handler.
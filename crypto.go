@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/go-redis/redis"
+	log "github.com/sirupsen/logrus"
+)
+
+// sealedPrefix marks values encrypted by a Keyring, which are stored as 'enc:<key id>:<base64 nonce & ciphertext>'.
+// Values without it were stored before encryption was enabled, and are read as they are.
+const sealedPrefix = "enc:"
+
+var (
+	errUnknownKey    = errors.New("value was encrypted with an unknown key")
+	errMalformedSeal = errors.New("encrypted value is malformed")
+)
+
+// Sealer protects sensitive values before they're persisted, and recovers them once read back.
+type Sealer interface {
+	Seal(plaintext []byte) ([]byte, error)
+	Open(stored []byte) ([]byte, error)
+}
+
+// plainSealer stores values as they are, used when no encryption keys are configured.
+type plainSealer struct{}
+
+func (plainSealer) Seal(plaintext []byte) ([]byte, error) {
+	return plaintext, nil
+}
+
+func (plainSealer) Open(stored []byte) ([]byte, error) {
+	if strings.HasPrefix(string(stored), sealedPrefix) {
+		return nil, errUnknownKey
+	}
+	return stored, nil
+}
+
+// Keyring encrypts values with AES-GCM under its primary key, and decrypts values sealed with any of its keys.
+// Keeping old keys around allows them to be rotated, re-encrypting existing values with the reencrypt command.
+type Keyring struct {
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+// NewKeyring creates a keyring from base64 encoded AES keys (16, 24 or 32 bytes), keyed by their identifiers.
+func NewKeyring(encodedKeys map[string]string, primary string) (*Keyring, error) {
+	keyring := &Keyring{primary: primary, keys: make(map[string]cipher.AEAD, len(encodedKeys))}
+
+	for id, encoded := range encodedKeys {
+		if id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("invalid key id %q", id)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode key %q: %w", id, err)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q: %w", id, err)
+		}
+		keyring.keys[id], err = cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q: %w", id, err)
+		}
+	}
+
+	if _, ok := keyring.keys[primary]; !ok {
+		return nil, fmt.Errorf("primary key %q is not configured", primary)
+	}
+	return keyring, nil
+}
+
+func (keyring *Keyring) Seal(plaintext []byte) ([]byte, error) {
+	aead := keyring.keys[keyring.primary]
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	// The key id is authenticated too, so a value can't be passed off as sealed by another key
+	sealed := aead.Seal(nonce, nonce, plaintext, []byte(keyring.primary))
+	return []byte(sealedPrefix + keyring.primary + ":" + base64.StdEncoding.EncodeToString(sealed)), nil
+}
+
+func (keyring *Keyring) Open(stored []byte) ([]byte, error) {
+	if !strings.HasPrefix(string(stored), sealedPrefix) {
+		return stored, nil
+	}
+
+	id, encoded, ok := strings.Cut(strings.TrimPrefix(string(stored), sealedPrefix), ":")
+	if !ok {
+		return nil, errMalformedSeal
+	}
+	aead, ok := keyring.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errUnknownKey, id)
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return nil, errMalformedSeal
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(id))
+}
+
+// Sealed returns true if a stored value is encrypted with the keyring's primary key, so doesn't need re-encrypting.
+func (keyring *Keyring) Sealed(stored []byte) bool {
+	return strings.HasPrefix(string(stored), sealedPrefix+keyring.primary+":")
+}
+
+// parseKeys reads 'id=base64key' pairs, separated by commas or newlines. Blank lines and '#' comments are ignored.
+// The identifiers are returned in the order listed.
+func parseKeys(text string, keys map[string]string) ([]string, error) {
+	var order []string
+	scanner := bufio.NewScanner(strings.NewReader(strings.ReplaceAll(text, ",", "\n")))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// Base64 padding also uses '=', so only the first one separates the id
+		id, key, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("expected 'id=key', got %q", line)
+		}
+		keys[strings.TrimSpace(id)] = strings.TrimSpace(key)
+		order = append(order, strings.TrimSpace(id))
+	}
+	return order, scanner.Err()
+}
+
+// loadSealer configures encryption from the environment.
+// Keys are read from ENCRYPTION_KEYS and the file at ENCRYPTION_KEY_FILE, and new values are sealed with
+// the key named by ENCRYPTION_KEY_ID, defaulting to the last key listed. Without keys, values are stored unencrypted.
+func loadSealer() (Sealer, error) {
+	keys := make(map[string]string)
+	order, err := parseKeys(os.Getenv("ENCRYPTION_KEYS"), keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ENCRYPTION_KEYS: %w", err)
+	}
+
+	if path := os.Getenv("ENCRYPTION_KEY_FILE"); path != "" {
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read encryption key file: %w", err)
+		}
+		fileOrder, err := parseKeys(string(contents), keys)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse encryption key file: %w", err)
+		}
+		order = append(order, fileOrder...)
+	}
+
+	if len(keys) == 0 {
+		return plainSealer{}, nil
+	}
+
+	primary := os.Getenv("ENCRYPTION_KEY_ID")
+	if primary == "" {
+		primary = order[len(order)-1]
+	}
+	return NewKeyring(keys, primary)
+}
+
+// sealer is the Sealer used for every sensitive value persisted. Misconfigured keys are fatal, as data would be unreadable.
+var sealer = sync.OnceValue(func() Sealer {
+	configured, err := loadSealer()
+	if err != nil {
+		log.WithField("error", err).Fatal("Invalid encryption configuration")
+	}
+	if _, ok := configured.(plainSealer); ok {
+		log.Warn("No encryption keys configured, sensitive data is stored unencrypted")
+	}
+	return configured
+})
+
+// seal encrypts a value for storage.
+func seal(plaintext []byte) []byte {
+	sealed, err := sealer().Seal(plaintext)
+	if err != nil {
+		log.WithField("error", err).Panic("Unable to encrypt value")
+	}
+	return sealed
+}
+
+// unseal decrypts a stored value, which may have been stored before encryption was enabled.
+func unseal(stored string) ([]byte, error) {
+	return sealer().Open([]byte(stored))
+}
+
+// sealJSON encodes a value as JSON, then encrypts it for storage.
+func sealJSON(value any) ([]byte, error) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return seal(encoded), nil
+}
+
+// unsealJSON decrypts a stored value, then decodes its JSON into value.
+func unsealJSON(stored string, value any) error {
+	decrypted, err := unseal(stored)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(decrypted, value)
+}
+
+// reencryptAttempts is how many times ReencryptCommand tries a key that keeps being changed while it's re-encrypted.
+const reencryptAttempts = 5
+
+// sealedStrings are the patterns of keys holding a single sealed string value.
+var sealedStrings = []string{
+	"code:*", "apartment:*", "submission:*", "renewal:*", "invite:*", "parking_request:*",
+	"event_card:*", "shared_code:*", "guild:*", "job:*", "form_schema:*",
+}
+
+// ReencryptCommand re-encrypts every sensitive value stored with the primary key, including values stored before
+// encryption was enabled. Old keys must remain configured until it has run, as they're needed to decrypt.
+func ReencryptCommand(args []string) {
+	flags := flag.NewFlagSet("reencrypt", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "Count the values that need re-encrypting without changing them")
+	flags.Parse(args)
+
+	keyring, ok := sealer().(*Keyring)
+	if !ok {
+		log.Fatal("No encryption keys configured, set ENCRYPTION_KEYS or ENCRYPTION_KEY_FILE")
+	}
+
+	// Each key is re-encrypted within a transaction, so changes the bot makes meanwhile (such as pushing a new history
+	// entry) aren't undone or misplaced. It's retried if the key changes first, counting only the attempt that succeeds.
+	resealed, failed := 0, 0
+	reencrypt := func(key string, rewrite func(tx *redis.Tx, reseal func(raw string) (string, bool)) error) {
+		for attempt := 0; attempt < reencryptAttempts; attempt++ {
+			attemptResealed, attemptFailed := 0, 0
+			reseal := func(raw string) (string, bool) {
+				if keyring.Sealed([]byte(raw)) {
+					return "", false
+				}
+				plaintext, err := keyring.Open([]byte(raw))
+				if err != nil {
+					log.WithFields(log.Fields{"error": err, "key": key}).Warn("Failed to decrypt value")
+					attemptFailed++
+					return "", false
+				}
+				sealed, err := keyring.Seal(plaintext)
+				if err != nil {
+					log.WithFields(log.Fields{"error": err, "key": key}).Fatal("Unable to encrypt value")
+				}
+				attemptResealed++
+				return string(sealed), true
+			}
+
+			err := db.Watch(func(tx *redis.Tx) error {
+				return rewrite(tx, reseal)
+			}, key)
+			if err == redis.TxFailedErr {
+				continue
+			} else if err != nil {
+				log.WithFields(log.Fields{"error": err, "key": key}).Warn("Failed to re-encrypt values")
+				failed++
+				return
+			}
+
+			resealed += attemptResealed
+			failed += attemptFailed
+			return
+		}
+
+		log.WithField("key", key).Warn("Values changed too often to be re-encrypted")
+		failed++
+	}
+
+	for _, pattern := range sealedStrings {
+		for _, key := range scanKeys(pattern) {
+			reencrypt(key, func(tx *redis.Tx, reseal func(raw string) (string, bool)) error {
+				raw, err := tx.Get(key).Result()
+				if err != nil {
+					// The key was removed meanwhile
+					return nil
+				}
+				sealed, ok := reseal(raw)
+				if !ok || *dryRun {
+					return nil
+				}
+
+				// Setting a value clears its expiry, so the remaining time is carried over
+				ttl := tx.PTTL(key).Val()
+				if ttl < 0 {
+					ttl = 0
+				}
+				_, err = tx.Pipelined(func(pipe redis.Pipeliner) error {
+					pipe.Set(key, sealed, ttl)
+					return nil
+				})
+				return err
+			})
+		}
+	}
+
+	// Vehicles stored before encryption are keyed by plate, so they're moved to a random field
+	for _, key := range scanKeys("vehicles:*") {
+		reencrypt(key, func(tx *redis.Tx, reseal func(raw string) (string, bool)) error {
+			changed := map[string]string{}
+			for field, raw := range tx.HGetAll(key).Val() {
+				if sealed, ok := reseal(raw); ok {
+					changed[field] = sealed
+				}
+			}
+			if len(changed) == 0 || *dryRun {
+				return nil
+			}
+
+			_, err := tx.Pipelined(func(pipe redis.Pipeliner) error {
+				for field, sealed := range changed {
+					pipe.HDel(key, field)
+					pipe.HSet(key, NewIdentifier(), sealed)
+				}
+				return nil
+			})
+			return err
+		})
+	}
+
+	// Lists are read and rewritten as a whole, so an index can't refer to an entry that has since moved
+	for _, pattern := range []string{"history:*", "capture:*"} {
+		for _, key := range scanKeys(pattern) {
+			reencrypt(key, func(tx *redis.Tx, reseal func(raw string) (string, bool)) error {
+				changed := map[int64]string{}
+				for index, raw := range tx.LRange(key, 0, -1).Val() {
+					if sealed, ok := reseal(raw); ok {
+						changed[int64(index)] = sealed
+					}
+				}
+				if len(changed) == 0 || *dryRun {
+					return nil
+				}
+
+				_, err := tx.Pipelined(func(pipe redis.Pipeliner) error {
+					for index, sealed := range changed {
+						pipe.LSet(key, index, sealed)
+					}
+					return nil
+				})
+				return err
+			})
+		}
+	}
+
+	for _, key := range scanKeys("active:*") {
+		reencrypt(key, func(tx *redis.Tx, reseal func(raw string) (string, bool)) error {
+			var removed []interface{}
+			var added []redis.Z
+			for _, entry := range tx.ZRangeWithScores(key, 0, -1).Val() {
+				raw, _ := entry.Member.(string)
+				if sealed, ok := reseal(raw); ok {
+					removed = append(removed, raw)
+					added = append(added, redis.Z{Score: entry.Score, Member: sealed})
+				}
+			}
+			if len(added) == 0 || *dryRun {
+				return nil
+			}
+
+			_, err := tx.Pipelined(func(pipe redis.Pipeliner) error {
+				pipe.ZRem(key, removed...)
+				pipe.ZAdd(key, added...)
+				return nil
+			})
+			return err
+		})
+	}
+
+	fields := log.Fields{"key": keyring.primary, "values": resealed, "failed": failed}
+	if *dryRun {
+		log.WithFields(fields).Info("Values that need re-encrypting")
+	} else {
+		log.WithFields(fields).Info("Re-encrypted stored values")
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Keys of each size AES accepts, all of which end in base64 padding
+var (
+	testKey16 = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 16))
+	testKey32 = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32))
+)
+
+// newTestKeyring creates a keyring, failing the test if it can't be.
+func newTestKeyring(t *testing.T, keys map[string]string, primary string) *Keyring {
+	t.Helper()
+
+	keyring, err := NewKeyring(keys, primary)
+	if err != nil {
+		t.Fatalf("failed to create keyring: %v", err)
+	}
+	return keyring
+}
+
+func TestKeyringRoundTrip(t *testing.T) {
+	keyring := newTestKeyring(t, map[string]string{"a": testKey32}, "a")
+
+	for _, plaintext := range []string{"", "ABCDEFGH", "{\"plate\":\"ABC1234\"}"} {
+		sealed, err := keyring.Seal([]byte(plaintext))
+		if err != nil {
+			t.Fatalf("failed to seal %q: %v", plaintext, err)
+		}
+		if !strings.HasPrefix(string(sealed), sealedPrefix+"a:") || (plaintext != "" && strings.Contains(string(sealed), plaintext)) {
+			t.Errorf("sealed = %q, want %q encrypted under key a", sealed, plaintext)
+		}
+		if !keyring.Sealed(sealed) {
+			t.Errorf("%q isn't reported as sealed with the primary key", sealed)
+		}
+
+		opened, err := keyring.Open(sealed)
+		if err != nil || string(opened) != plaintext {
+			t.Errorf("opened = %q (%v), want %q", opened, err, plaintext)
+		}
+	}
+
+	// Values stored before encryption was enabled are read as they are
+	if opened, err := keyring.Open([]byte("ABCDEFGH")); err != nil || string(opened) != "ABCDEFGH" {
+		t.Errorf("opened = %q (%v), want the unencrypted value", opened, err)
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	old := newTestKeyring(t, map[string]string{"old": testKey16}, "old")
+	sealed, err := old.Seal([]byte("ABCDEFGH"))
+	if err != nil {
+		t.Fatalf("failed to seal: %v", err)
+	}
+
+	// Once rotated, values sealed with the old key are still read, but need re-encrypting
+	rotated := newTestKeyring(t, map[string]string{"old": testKey16, "new": testKey32}, "new")
+	if opened, err := rotated.Open(sealed); err != nil || string(opened) != "ABCDEFGH" {
+		t.Errorf("opened = %q (%v), want the value sealed with the old key", opened, err)
+	}
+	if rotated.Sealed(sealed) {
+		t.Error("value sealed with the old key is reported as sealed with the new one")
+	}
+
+	// Once the old key is removed, they can't be read at all
+	removed := newTestKeyring(t, map[string]string{"new": testKey32}, "new")
+	if _, err := removed.Open(sealed); !errors.Is(err, errUnknownKey) {
+		t.Errorf("error = %v, want %v", err, errUnknownKey)
+	}
+	if _, err := (plainSealer{}).Open(sealed); !errors.Is(err, errUnknownKey) {
+		t.Errorf("error without keys = %v, want %v", err, errUnknownKey)
+	}
+}
+
+func TestKeyringTampering(t *testing.T) {
+	keyring := newTestKeyring(t, map[string]string{"a": testKey32, "b": testKey16}, "a")
+	sealed, err := keyring.Seal([]byte("ABCDEFGH"))
+	if err != nil {
+		t.Fatalf("failed to seal: %v", err)
+	}
+	encoded := strings.TrimPrefix(string(sealed), sealedPrefix+"a:")
+	raw, _ := base64.StdEncoding.DecodeString(encoded)
+	flipped := slices.Clone(raw)
+	flipped[len(flipped)-1] ^= 1
+
+	tests := []struct {
+		name   string
+		stored string
+	}{
+		{"modified ciphertext", sealedPrefix + "a:" + base64.StdEncoding.EncodeToString(flipped)},
+		{"other key id", sealedPrefix + "b:" + encoded},
+		{"truncated", sealedPrefix + "a:" + base64.StdEncoding.EncodeToString(raw[:4])},
+		{"not base64", sealedPrefix + "a:!!!"},
+		{"no key id", sealedPrefix + encoded},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if opened, err := keyring.Open([]byte(test.stored)); err == nil {
+				t.Errorf("opened = %q, want an error", opened)
+			}
+		})
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	keys := map[string]string{}
+	order, err := parseKeys("# Rotated in 2024\nold = "+testKey16+"\n\nnew="+testKey32+",extra=QUJD==", keys)
+	if err != nil {
+		t.Fatalf("failed to parse keys: %v", err)
+	}
+
+	if !slices.Equal(order, []string{"old", "new", "extra"}) {
+		t.Errorf("order = %v, want old, new, extra", order)
+	}
+	// Padding is kept as part of the key, rather than separating another id
+	if keys["old"] != testKey16 || keys["new"] != testKey32 || keys["extra"] != "QUJD==" {
+		t.Errorf("keys = %v", keys)
+	}
+	if _, err := NewKeyring(map[string]string{"old": keys["old"], "new": keys["new"]}, order[1]); err != nil {
+		t.Errorf("failed to create keyring from parsed keys: %v", err)
+	}
+
+	if _, err := parseKeys("missing-separator", map[string]string{}); err == nil {
+		t.Error("parsed a key without an id")
+	}
+}
+
+func TestNewKeyringErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    map[string]string
+		primary string
+	}{
+		{"missing primary", map[string]string{"a": testKey32}, "b"},
+		{"wrong size", map[string]string{"a": base64.StdEncoding.EncodeToString([]byte("short"))}, "a"},
+		{"not base64", map[string]string{"a": "!!!"}, "a"},
+		{"id with separator", map[string]string{"a:b": testKey32}, "a:b"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := NewKeyring(test.keys, test.primary); err == nil {
+				t.Error("keyring was created, want an error")
+			}
+		})
+	}
+}
@@ -2,8 +2,12 @@ package main
 
 import (
 	"fmt"
+	"slices"
+	"strings"
+	"time"
 
-	"github.com/go-redis/redis"
+	"github.com/samber/lo"
+	log "github.com/sirupsen/logrus"
 )
 
 // LocationExists checks if a location identifier is valid (as known by the cache).
@@ -13,26 +17,90 @@ func LocationExists(location int64) bool {
 	return ok
 }
 
-// StoreCode stores a guest code for a given location and member ID.
-func StoreCode(code string, location int64, member_id int) bool {
+// GetLocationName returns the name of a location, falling back to its identifier if it is not cached.
+func GetLocationName(location uint) string {
+	if cached, ok := cachedLocationsMap[location]; ok {
+		return cached.name
+	}
+	// Properties missing from the cached list may still have had their form cached when a guest code was validated
+	if schema, ok := GetFormSchema(location); ok && schema.PropertyName != "" {
+		return schema.PropertyName
+	}
+	return fmt.Sprintf("Property #%d", location)
+}
+
+// StoreCode stores a guest code for a given location and member ID, along with its status (see CodeVerified, etc.).
+func StoreCode(code string, location int64, member_id int, status string) bool {
 	key := fmt.Sprintf("code:%d:%d", location, member_id)
 	already_set := db.Exists(key).Val() == 1
-	db.Set(key, code, 0)
+	db.Set(key, seal([]byte(code)), 0)
+	SetCodeStatus(location, member_id, status)
 
 	return already_set
 }
 
+// SetCodeStatus records the status of the guest code for a given location and member ID.
+func SetCodeStatus(location int64, member_id int, status string) {
+	key := fmt.Sprintf("code_status:%d:%d", location, member_id)
+	db.Set(key, status, 0)
+}
+
+// GetCodeStatus returns the status of the guest code for a given location and member ID.
+// Codes stored before statuses were introduced are assumed to be verified.
+func GetCodeStatus(location int64, member_id int) string {
+	key := fmt.Sprintf("code_status:%d:%d", location, member_id)
+	status, err := db.Get(key).Result()
+	if err != nil {
+		return CodeVerified
+	}
+	return status
+}
+
 // GetCode returns the guest code for a given location and member ID.
 func GetCode(location int64, member_id int) (string, bool) {
 	key := fmt.Sprintf("code:%d:%d", location, member_id)
-	result := db.Get(key)
-	return result.Val(), result.Err() == redis.Nil
+	return getSealed(key)
+}
+
+// getSealed returns the decrypted string stored under the given key, if it exists and can be decrypted.
+func getSealed(key string) (string, bool) {
+	stored, err := db.Get(key).Result()
+	if err != nil {
+		return "", false
+	}
+	value, err := unseal(stored)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err, "key": key}).Warn("Failed to decrypt value")
+		return "", false
+	}
+	return string(value), true
 }
 
 // RemoveCode removes a guest code for a given location and member ID.
 func RemoveCode(location int64, member_id int) {
-	key := fmt.Sprintf("code:%d:%d", location, member_id)
-	db.Del(key)
+	db.Del(fmt.Sprintf("code:%d:%d", location, member_id), fmt.Sprintf("code_status:%d:%d", location, member_id))
+}
+
+// GetCodes returns every guest code stored by a given member ID, keyed by location.
+func GetCodes(member_id int) map[uint]string {
+	codes := make(map[uint]string)
+
+	iterator := db.Scan(0, fmt.Sprintf("code:*:%d", member_id), 100).Iterator()
+	for iterator.Next() {
+		var location uint
+		var member int
+		if _, err := fmt.Sscanf(iterator.Val(), "code:%d:%d", &location, &member); err != nil || member != member_id {
+			continue
+		}
+		if code, ok := getSealed(iterator.Val()); ok {
+			codes[location] = code
+		}
+	}
+	if err := iterator.Err(); err != nil {
+		log.WithFields(log.Fields{"error": err, "member": member_id}).Error("Failed to scan stored codes")
+	}
+
+	return codes
 }
 
 // SetCodeRequirement sets whether or not a guest code is required for a given location.
@@ -50,8 +118,149 @@ func GetCodeRequirement(location int64) uint {
 	if db.Exists(key).Val() == 0 {
 		return Unknown
 	}
-	if db.Get(key).Val() == "true" {
+	// Booleans are stored as integers, so "1" is true
+	if db.Get(key).Val() == "1" {
 		return GuestCodeRequired
 	}
 	return GuestCodeNotRequired
 }
+
+// StoreFormSchema caches the structure of a property's registration form.
+func StoreFormSchema(location uint, schema FormSchema) {
+	storeJSON(fmt.Sprintf("form_schema:%d", location), schema, 0)
+}
+
+// GetFormSchema returns the cached structure of a property's registration form.
+func GetFormSchema(location uint) (FormSchema, bool) {
+	var schema FormSchema
+	ok := loadJSON(fmt.Sprintf("form_schema:%d", location), &schema)
+	return schema, ok
+}
+
+// scanKeys returns every key matching a pattern.
+func scanKeys(pattern string) []string {
+	keys := make([]string, 0)
+	iterator := db.Scan(0, pattern, 100).Iterator()
+	for iterator.Next() {
+		keys = append(keys, iterator.Val())
+	}
+	if err := iterator.Err(); err != nil {
+		log.WithFields(log.Fields{"error": err, "pattern": pattern}).Error("Failed to scan keys")
+	}
+	return keys
+}
+
+// storeJSON encodes a value as JSON and stores it under the given key.
+func storeJSON(key string, value any, expiration time.Duration) {
+	encoded, err := sealJSON(value)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err, "key": key}).Error("Failed to encode value")
+		return
+	}
+	db.Set(key, encoded, expiration)
+}
+
+// loadJSON decodes the JSON stored under the given key into value.
+// False is returned if the key does not exist or the value could not be decoded.
+func loadJSON(key string, value any) bool {
+	raw, err := db.Get(key).Result()
+	if err != nil {
+		return false
+	}
+	if err := unsealJSON(raw, value); err != nil {
+		log.WithFields(log.Fields{"error": err, "key": key}).Warn("Failed to decode value")
+		return false
+	}
+	return true
+}
+
+// StoreSubmission stores the values used for a registration so it can be repeated for a limited time.
+func StoreSubmission(id string, submission Submission) {
+	storeJSON(fmt.Sprintf("submission:%s", id), submission, 7*24*time.Hour)
+}
+
+// GetSubmission returns the values stored for a previous registration.
+func GetSubmission(id string) (Submission, bool) {
+	var submission Submission
+	ok := loadJSON(fmt.Sprintf("submission:%s", id), &submission)
+	return submission, ok
+}
+
+// StoreApartment stores the apartment number a member registers with at a given location.
+func StoreApartment(location uint, member_id int, apartment string) {
+	db.Set(fmt.Sprintf("apartment:%d:%d", location, member_id), seal([]byte(apartment)), 0)
+}
+
+// GetApartment returns the apartment number a member registers with at a given location, if known.
+func GetApartment(location uint, member_id int) (string, bool) {
+	apartment, ok := getSealed(fmt.Sprintf("apartment:%d:%d", location, member_id))
+	return apartment, ok && apartment != ""
+}
+
+// StoreVehicle stores (or replaces) a vehicle profile for a given member, identified by its license plate.
+// As plates are sensitive, new profiles are stored under a random field rather than the plate itself.
+// Apartment numbers only apply to one property, so are kept by StoreApartment rather than with the profile.
+func StoreVehicle(member int, profile VehicleProfile) {
+	key := fmt.Sprintf("vehicles:%d", member)
+	profile.Plate = strings.ToUpper(profile.Plate)
+	profile.Values = lo.OmitByKeys(profile.Values, []string{"vehicleApt"})
+
+	field, _, ok := findVehicle(member, profile.Plate)
+	if !ok {
+		field = NewIdentifier()
+	}
+
+	encoded, err := sealJSON(profile)
+	if err != nil {
+		log.WithField("error", err).Error("Failed to encode vehicle profile")
+		return
+	}
+	db.HSet(key, field, encoded)
+}
+
+// GetVehicle returns the vehicle profile for a given member and license plate.
+func GetVehicle(member int, plate string) (VehicleProfile, bool) {
+	_, profile, ok := findVehicle(member, plate)
+	return profile, ok
+}
+
+// findVehicle returns a member's vehicle profile with the given license plate, along with the field it's stored under.
+// Profiles stored before encryption was enabled are stored under their plate, later ones under a random field.
+func findVehicle(member int, plate string) (string, VehicleProfile, bool) {
+	key := fmt.Sprintf("vehicles:%d", member)
+	plate = strings.ToUpper(plate)
+
+	for field, raw := range db.HGetAll(key).Val() {
+		var profile VehicleProfile
+		if err := unsealJSON(raw, &profile); err != nil {
+			log.WithFields(log.Fields{"error": err, "member": member}).Warn("Failed to decode vehicle profile")
+			continue
+		}
+		if profile.Plate == plate {
+			return field, profile, true
+		}
+	}
+	return "", VehicleProfile{}, false
+}
+
+// GetVehicles returns every vehicle profile stored for a given member.
+func GetVehicles(member int) []VehicleProfile {
+	key := fmt.Sprintf("vehicles:%d", member)
+	profiles := make([]VehicleProfile, 0)
+
+	for _, raw := range db.HGetAll(key).Val() {
+		var profile VehicleProfile
+		if err := unsealJSON(raw, &profile); err != nil {
+			log.WithFields(log.Fields{"error": err, "member": member}).Warn("Failed to decode vehicle profile")
+			continue
+		}
+		profiles = append(profiles, profile)
+	}
+
+	// Hash ordering is not stable, so sort for consistent autocomplete results
+	slices.SortFunc(profiles, func(a, b VehicleProfile) int {
+		return strings.Compare(a.Plate, b.Plate)
+	})
+
+	return profiles
+}
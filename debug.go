@@ -1,11 +1,19 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
+	"time"
 )
 
+// debugBodyLimit caps how much of a body DebugRequest and DebugResponse include.
+const debugBodyLimit = 4 * 1024
+
+// DebugRequest describes a request's method, URL, headers and body, with personal details redacted.
+// The body is restored after being read, so the request can still be sent.
 func DebugRequest(req *http.Request) string {
 	str := fmt.Sprintf("[%s %s]", req.Method, req.URL.String())
 
@@ -17,23 +25,16 @@ func DebugRequest(req *http.Request) string {
 	}
 
 	// Add body
-	if req.Body != nil {
-		body, err := io.ReadAll(req.Body)
-
-		if err != nil {
-			str += fmt.Sprintf("\n\n {error while reading request body buffer: %s}", err)
-		} else {
-			if len(body) == 0 {
-				str += "\n\n{empty body}"
-			} else {
-				str += fmt.Sprintf("\n\n%s", body)
-			}
-		}
+	if req.Body != nil || req.GetBody != nil {
+		body, err := peekRequestBody(req)
+		str += debugBody("request", body, err)
 	}
 
-	return str
+	return Redact(str)
 }
 
+// DebugResponse describes a response's status, URL, headers and body, with personal details redacted.
+// The body is restored after being read, so the response can still be parsed.
 func DebugResponse(res *http.Response) string {
 	str := fmt.Sprintf("[%s %s]", res.Status, res.Request.URL.String())
 	// Add all headers
@@ -45,18 +46,71 @@ func DebugResponse(res *http.Response) string {
 
 	// Add body
 	if res.Body != nil {
-		body, err := io.ReadAll(res.Body)
+		body, err := peekResponseBody(res)
+		str += debugBody("response", body, err)
+	}
+
+	return Redact(str)
+}
+
+// DebugHAR describes a response and the request it was for as a HAR archive, which can be replayed or opened in a browser.
+// Unlike DebugRequest and DebugResponse, bodies are included in full.
+func DebugHAR(res *http.Response, started time.Time) string {
+	entry, err := NewHAREntry(res.Request, res, started, time.Since(started))
+	if err != nil {
+		return fmt.Sprintf("{error while recording HAR entry: %s}", err)
+	}
+
+	var encoded strings.Builder
+	if err := WriteHAR(&encoded, entry); err != nil {
+		return fmt.Sprintf("{error while encoding HAR entry: %s}", err)
+	}
+	return encoded.String()
+}
+
+// debugBody formats a body for DebugRequest and DebugResponse, truncated to debugBodyLimit.
+func debugBody(kind string, body []byte, err error) string {
+	if err != nil {
+		return fmt.Sprintf("\n\n {error while reading %s body buffer: %s}", kind, err)
+	} else if len(body) == 0 {
+		return "\n\n{empty body}"
+	} else if len(body) > debugBodyLimit {
+		return fmt.Sprintf("\n\n%s\n{%d more bytes}", body[:debugBodyLimit], len(body)-debugBodyLimit)
+	}
+	return fmt.Sprintf("\n\n%s", body)
+}
 
+// peekRequestBody returns a request's body without consuming it.
+// Requests that have already been sent are read with GetBody, as their body has been consumed by the transport.
+func peekRequestBody(req *http.Request) ([]byte, error) {
+	if req.GetBody != nil {
+		body, err := req.GetBody()
 		if err != nil {
-			str += fmt.Sprintf("\n\n {error while reading response body buffer: %s}", err)
-		} else {
-			if len(body) == 0 {
-				str += "\n\n{empty body}"
-			} else {
-				str += fmt.Sprintf("\n\n%s", body)
-			}
+			return nil, err
 		}
+		defer body.Close()
+		return io.ReadAll(body)
 	}
 
-	return str
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	return body, err
+}
+
+// peekResponseBody returns a response's body, replacing it with a buffered copy so it can still be read.
+func peekResponseBody(res *http.Response) ([]byte, error) {
+	if res.Body == nil || res.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	return body, err
 }
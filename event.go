@@ -0,0 +1,402 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/samber/lo"
+	"github.com/sirupsen/logrus"
+)
+
+// eventDateLayout is the format event dates are entered in.
+const eventDateLayout = "2006-01-02"
+
+// Outcomes of an attendee's registration, as counted on an event card.
+const (
+	attendanceApproved = "approved"
+	attendanceDenied   = "denied"
+)
+
+// StoreEventCard stores an event card until the day after it closes, in case its close job is missed.
+func StoreEventCard(card EventCard) {
+	storeJSON(fmt.Sprintf("event_card:%s", card.ID), card, time.Until(card.Closes)+24*time.Hour)
+}
+
+// GetEventCard returns an event card, including one that has closed but is yet to expire (see Closed).
+func GetEventCard(id string) (EventCard, bool) {
+	var card EventCard
+	ok := loadJSON(fmt.Sprintf("event_card:%s", id), &card)
+	return card, ok
+}
+
+// Closed returns whether registration through the card has ended, whether or not its close job has run.
+func (card EventCard) Closed() bool {
+	return time.Now().After(card.Closes)
+}
+
+// RemoveEventCard removes an event card and its attendance.
+func RemoveEventCard(id string) {
+	db.Del(fmt.Sprintf("event_card:%s", id), fmt.Sprintf("event_attendance:%s", id))
+}
+
+// RecordAttendance records the outcome of an attendee's registration, replacing any earlier outcome of theirs.
+// Attendees are counted once, so registering again after being denied moves them to approved.
+func RecordAttendance(card EventCard, member int, approved bool) {
+	key := fmt.Sprintf("event_attendance:%s", card.ID)
+	outcome := attendanceDenied
+	if approved {
+		outcome = attendanceApproved
+	}
+	db.HSet(key, strconv.Itoa(member), outcome)
+	db.ExpireAt(key, card.Closes.Add(24*time.Hour))
+}
+
+// GetAttendance returns the number of attendees whose registrations were approved & denied.
+func GetAttendance(id string) (approved int, denied int) {
+	outcomes, err := db.HGetAll(fmt.Sprintf("event_attendance:%s", id)).Result()
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "event": id}).Warn("Failed to retrieve event attendance")
+	}
+	for _, outcome := range outcomes {
+		if outcome == attendanceApproved {
+			approved++
+		} else {
+			denied++
+		}
+	}
+	return approved, denied
+}
+
+// attend marks the context as registering an event's attendee. The event's guest code isn't persisted with the submission.
+func (context *RegisterContext) attend(event string) {
+	context.event = event
+	context.guestCode = ""
+}
+
+var EventCardCommandDefinition = &discordgo.ApplicationCommand{
+	Name:        "event-card",
+	Description: "Post a card for attendees of an event to register their vehicles with",
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:         discordgo.ApplicationCommandOptionString,
+			Name:         "location",
+			Description:  "The complex attendees park at",
+			Required:     true,
+			Autocomplete: true,
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "date",
+			Description: "The day of the event (YYYY-MM-DD), if not linked to a scheduled event",
+			Required:    false,
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "code",
+			Description: "A guest code for attendees to register with, without seeing it",
+			Required:    false,
+		},
+		{
+			Type:         discordgo.ApplicationCommandOptionString,
+			Name:         "event",
+			Description:  "The scheduled event in this server to link the card to",
+			Required:     false,
+			Autocomplete: true,
+		},
+	},
+}
+
+// EventCardCommandHandler posts an event card in the channel the command was invoked in.
+func EventCardCommandHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+	log := logrus.WithFields(logrus.Fields{
+		"interaction": interaction.ID,
+		"user":        GetUser(interaction).ID,
+		"command":     "event-card",
+	})
+
+	data := interaction.ApplicationCommandData()
+	options := lo.SliceToMap(data.Options, func(option *discordgo.ApplicationCommandInteractionDataOption) (string, *discordgo.ApplicationCommandInteractionDataOption) {
+		return option.Name, option
+	})
+
+	switch interaction.Type {
+
+	case discordgo.InteractionApplicationCommand:
+		if interaction.GuildID == "" {
+			HandleError(session, interaction, nil, ":x: Event cards can only be posted in a server.")
+			return
+		}
+
+		locationId, parseErr := strconv.ParseUint(options["location"].StringValue(), 10, 64)
+		if parseErr != nil || !LocationExists(int64(locationId)) {
+			HandleError(session, interaction, parseErr, ":x: The location provided does not exist.")
+			return
+		}
+
+		organizer, parseErr := strconv.Atoi(GetUser(interaction).ID)
+		if parseErr != nil {
+			HandleError(session, interaction, parseErr, "Error occurred while parsing user id")
+			return
+		}
+
+		card := EventCard{
+			ID:         NewIdentifier(),
+			Guild:      interaction.GuildID,
+			ChannelId:  interaction.ChannelID,
+			Organizer:  organizer,
+			PropertyId: uint(locationId),
+		}
+
+		// A linked event provides the date, unless one is given explicitly
+		if option, ok := options["event"]; ok {
+			event, err := session.GuildScheduledEvent(interaction.GuildID, option.StringValue(), false)
+			if err != nil {
+				HandleError(session, interaction, err, ":x: The scheduled event provided does not exist in this server.")
+				return
+			}
+			card.EventId = event.ID
+			card.EventName = event.Name
+			card.Date = event.ScheduledStartTime.Local()
+		}
+		if option, ok := options["date"]; ok {
+			date, err := time.ParseInLocation(eventDateLayout, option.StringValue(), time.Local)
+			if err != nil {
+				HandleError(session, interaction, nil, ":x: The date provided must be formatted as YYYY-MM-DD.")
+				return
+			}
+			card.Date = date
+		} else if card.EventId == "" {
+			HandleError(session, interaction, nil, ":x: Provide either the date of the event, or a scheduled event to link.")
+			return
+		}
+
+		// Registration stays open until the end of the event's day
+		year, month, day := card.Date.Date()
+		card.Closes = time.Date(year, month, day+1, 0, 0, 0, 0, time.Local)
+		if card.Closes.Before(time.Now()) {
+			HandleError(session, interaction, nil, ":x: The event's date has already passed.")
+			return
+		}
+
+		// Checking the guest code and posting the card can take a while
+		err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Flags: discordgo.MessageFlagsEphemeral,
+			},
+		})
+		if err != nil {
+			log.WithField("error", err).Error("Failed to defer event card response")
+			return
+		}
+
+		if option, ok := options["code"]; ok {
+			card.GuestCode = option.StringValue()
+			if verified, accepted := ValidateCode(NewCapture(interaction), int64(locationId), card.GuestCode); verified && !accepted {
+				HandleError(session, interaction, nil, ":x: The guest code provided was rejected by Register2Park.")
+				return
+			}
+		}
+
+		// The card is posted by the bot, rather than as the interaction's response, so it can be updated long after
+		message, err := session.ChannelMessageSendComplex(card.ChannelId, &discordgo.MessageSend{
+			Embeds:          []*discordgo.MessageEmbed{EventCardEmbed(card, 0, 0)},
+			Components:      EventCardComponents(card, false),
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		})
+		if err != nil {
+			HandleError(session, interaction, err, ":x: Failed to post the event card. Make sure I can send messages in this channel.")
+			return
+		}
+		card.MessageId = message.ID
+
+		StoreEventCard(card)
+		ScheduleJob("event-close", card.ID, card.Closes)
+		log.WithFields(logrus.Fields{"event": card.ID, "location": card.PropertyId}).Info("Posted event card")
+
+		content := fmt.Sprintf("Event card posted. Registration closes <t:%d:R>.", card.Closes.Unix())
+		_, err = session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{
+			Content: &content,
+		})
+		if err != nil {
+			log.WithField("error", err).Error("Failed to respond to event card command")
+		}
+
+	case discordgo.InteractionApplicationCommandAutocomplete:
+		var choices []*discordgo.ApplicationCommandOptionChoice
+		if option, ok := options["location"]; ok && option.Focused {
+			choices = LocationChoices(interaction, option.StringValue())
+		} else if option, ok := options["event"]; ok && option.Focused && interaction.GuildID != "" {
+			choices = ScheduledEventChoices(session, interaction.GuildID)
+		}
+
+		err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+			Data: &discordgo.InteractionResponseData{
+				Choices: choices,
+			},
+		})
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// ScheduledEventChoices returns the upcoming scheduled events of a guild as autocomplete choices.
+func ScheduledEventChoices(session *discordgo.Session, guildId string) []*discordgo.ApplicationCommandOptionChoice {
+	events, err := session.GuildScheduledEvents(guildId, false)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "guild": guildId}).Warn("Failed to retrieve scheduled events")
+		return nil
+	}
+
+	events = lo.Filter(events, func(event *discordgo.GuildScheduledEvent, _ int) bool {
+		return event.Status == discordgo.GuildScheduledEventStatusScheduled || event.Status == discordgo.GuildScheduledEventStatusActive
+	})
+	if len(events) > 25 {
+		events = events[:25]
+	}
+
+	return lo.Map(events, func(event *discordgo.GuildScheduledEvent, _ int) *discordgo.ApplicationCommandOptionChoice {
+		return &discordgo.ApplicationCommandOptionChoice{
+			Name:  fmt.Sprintf("%s (%s)", event.Name, event.ScheduledStartTime.Local().Format("Jan 2")),
+			Value: event.ID,
+		}
+	})
+}
+
+// EventCardEmbed describes an event card, along with the number of attendees approved & denied so far.
+func EventCardEmbed(card EventCard, approved int, denied int) *discordgo.MessageEmbed {
+	title := "Event Parking"
+	if card.EventName != "" {
+		title = "Parking for " + card.EventName
+	}
+
+	guestCode := "Not provided"
+	if card.GuestCode != "" {
+		guestCode = "Provided by the organizer"
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title:       title,
+		Description: "Attending? Press the button below to register your vehicle for parking.",
+		Color:       0x5865f2,
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: GetFooterText(),
+		},
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Location", Value: GetLocationName(card.PropertyId), Inline: true},
+			{Name: "Date", Value: fmt.Sprintf("<t:%d:D>", card.Date.Unix()), Inline: true},
+			{Name: "Organizer", Value: fmt.Sprintf("<@%d>", card.Organizer), Inline: true},
+			{Name: "Guest Code", Value: guestCode, Inline: true},
+			{Name: "Approved", Value: strconv.Itoa(approved), Inline: true},
+			{Name: "Denied", Value: strconv.Itoa(denied), Inline: true},
+		},
+	}
+	if card.EventId != "" {
+		embed.URL = fmt.Sprintf("https://discord.com/events/%s/%s", card.Guild, card.EventId)
+	}
+	return embed
+}
+
+// EventCardComponents returns the button attendees register with, disabled once registration has closed.
+func EventCardComponents(card EventCard, closed bool) []discordgo.MessageComponent {
+	label := "Register my car"
+	if closed {
+		label = "Registration closed"
+	}
+
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					Label:    label,
+					Style:    discordgo.PrimaryButton,
+					CustomID: "event-register:" + card.ID,
+					Disabled: closed,
+				},
+			},
+		},
+	}
+}
+
+// UpdateEventCard edits an event card's message to show its current attendance.
+func UpdateEventCard(card EventCard, closed bool) error {
+	approved, denied := GetAttendance(card.ID)
+	embeds := []*discordgo.MessageEmbed{EventCardEmbed(card, approved, denied)}
+	components := EventCardComponents(card, closed)
+
+	_, err := session.ChannelMessageEditComplex(&discordgo.MessageEdit{
+		Channel:    card.ChannelId,
+		ID:         card.MessageId,
+		Embeds:     embeds,
+		Components: components,
+	})
+	return err
+}
+
+// RecordEventRegistration counts an attendee's registration on an event card, updating the card to match.
+func RecordEventRegistration(id string, member int, approved bool) {
+	card, ok := GetEventCard(id)
+	if !ok {
+		return
+	}
+
+	RecordAttendance(card, member, approved)
+	if err := UpdateEventCard(card, false); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "event": id}).Warn("Failed to update event card")
+	}
+}
+
+// EventRegisterHandler presents an attendee with the registration form for an event card's property.
+func EventRegisterHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+	_, cardId, _ := strings.Cut(interaction.MessageComponentData().CustomID, ":")
+	card, ok := GetEventCard(cardId)
+	if !ok || card.Closed() {
+		HandleError(session, interaction, nil, ":x: Registration for this event has closed.")
+		return
+	}
+
+	form := FetchForm(NewCapture(interaction), card.PropertyId, card.GuestCode)
+	if form.requireGuestCode {
+		message := ":x: This location requires a guest code, but the event's organizer didn't provide one. Use `/register` with your own code instead."
+		if card.GuestCode != "" {
+			message = ":x: The guest code provided by the event's organizer is no longer valid. Let them know, or use `/register` with your own code instead."
+		}
+		HandleError(session, interaction, nil, message)
+		return
+	} else if form.err != nil {
+		HandleError(session, interaction, form.err, ":x: Failed to retrieve the registration form.")
+		return
+	}
+
+	context, err := NewRegisterContext(form, card.PropertyId, card.GuestCode)
+	if err != nil {
+		HandleError(session, interaction, err, "Error occurred while parsing resident profile identifier")
+		return
+	}
+	context.attend(card.ID)
+
+	PresentRegistration(session, interaction, context, form, nil, "")
+}
+
+// EventCloseJobHandler closes an event card once its day is over, disabling its button.
+func EventCloseJobHandler(job *Job) error {
+	card, ok := GetEventCard(job.Target)
+	if !ok {
+		return nil
+	}
+
+	// The card's message may well have been deleted, which isn't worth retrying
+	var restErr *discordgo.RESTError
+	if err := UpdateEventCard(card, true); err != nil && !errors.As(err, &restErr) {
+		return fmt.Errorf("failed to close event card: %w", err)
+	}
+
+	RemoveEventCard(card.ID)
+	return nil
+}
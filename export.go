@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+)
+
+// historyColumns are the header columns of a CSV history export.
+var historyColumns = []string{
+	"id", "member", "property_id", "property_name", "plate", "approved",
+	"confirmation_code", "vehicle_id", "registered_at", "expires_at", "registered_by",
+}
+
+// WriteHistoryCSV writes history entries as CSV, with a header row.
+func WriteHistoryCSV(writer io.Writer, entries []HistoryEntry) error {
+	csvWriter := csv.NewWriter(writer)
+	if err := csvWriter.Write(historyColumns); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		expiresAt := ""
+		if !entry.ExpiresAt.IsZero() {
+			expiresAt = entry.ExpiresAt.Format(time.RFC3339)
+		}
+		registeredBy := ""
+		if entry.RegisteredBy != 0 {
+			registeredBy = strconv.Itoa(entry.RegisteredBy)
+		}
+
+		err := csvWriter.Write([]string{
+			entry.ID,
+			strconv.Itoa(entry.Member),
+			strconv.FormatUint(uint64(entry.PropertyId), 10),
+			entry.PropertyName,
+			entry.Plate,
+			strconv.FormatBool(entry.Approved),
+			entry.ConfirmationCode,
+			entry.VehicleId,
+			entry.RegisteredAt.Format(time.RFC3339),
+			expiresAt,
+			registeredBy,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// WriteHistoryJSON writes history entries as an indented JSON array.
+func WriteHistoryJSON(writer io.Writer, entries []HistoryEntry) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(entries)
+}
+
+// WriteHistory writes history entries in the given format, either "csv" or "json".
+func WriteHistory(writer io.Writer, entries []HistoryEntry, format string) error {
+	switch format {
+	case "csv":
+		return WriteHistoryCSV(writer, entries)
+	case "json":
+		return WriteHistoryJSON(writer, entries)
+	}
+	return fmt.Errorf("unknown export format: %s", format)
+}
+
+// icsEscape escapes text for use within an iCalendar property value (RFC 5545, section 3.3.11).
+func icsEscape(text string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(text)
+}
+
+// HistoryEntryICS builds an iCalendar file containing a single event covering an approved registration's validity window.
+func HistoryEntryICS(entry HistoryEntry) []byte {
+	const icsTime = "20060102T150405Z"
+
+	description := fmt.Sprintf("License Plate: %s\nConfirmation Code: %s", entry.Plate, entry.ConfirmationCode)
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//r2park//Registration//EN",
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+		"BEGIN:VEVENT",
+		fmt.Sprintf("UID:%s@r2park", entry.ID),
+		fmt.Sprintf("DTSTAMP:%s", time.Now().UTC().Format(icsTime)),
+		fmt.Sprintf("DTSTART:%s", entry.RegisteredAt.UTC().Format(icsTime)),
+		fmt.Sprintf("DTEND:%s", entry.ExpiresAt.UTC().Format(icsTime)),
+		fmt.Sprintf("SUMMARY:%s", icsEscape(fmt.Sprintf("Parking at %s (%s)", entry.PropertyName, entry.Plate))),
+		fmt.Sprintf("DESCRIPTION:%s", icsEscape(description)),
+	}
+	if location, ok := cachedLocationsMap[entry.PropertyId]; ok {
+		lines = append(lines, fmt.Sprintf("LOCATION:%s", icsEscape(location.address)))
+	}
+	lines = append(lines, "END:VEVENT", "END:VCALENDAR", "")
+
+	return []byte(strings.Join(lines, "\r\n"))
+}
+
+// GetAllHistory returns the registration history of every member, newest first.
+func GetAllHistory() []HistoryEntry {
+	entries := make([]HistoryEntry, 0)
+
+	iterator := db.Scan(0, "history:*", 100).Iterator()
+	for iterator.Next() {
+		_, memberId, _ := strings.Cut(iterator.Val(), ":")
+		member, parseErr := strconv.Atoi(memberId)
+		if parseErr != nil {
+			continue
+		}
+		entries = append(entries, GetHistory(member)...)
+	}
+	if err := iterator.Err(); err != nil {
+		log.WithField("error", err).Error("Failed to scan history keys")
+	}
+
+	slices.SortFunc(entries, func(a, b HistoryEntry) int {
+		return b.RegisteredAt.Compare(a.RegisteredAt)
+	})
+	return entries
+}
+
+// CalendarHandler attaches a calendar event for an approved registration.
+// The registration is identified by the custom ID ('calendar:id'), or the selected value when chosen from /history.
+func CalendarHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+	data := interaction.MessageComponentData()
+	_, entryId, _ := strings.Cut(data.CustomID, ":")
+	if len(data.Values) > 0 {
+		entryId = data.Values[0]
+	}
+
+	entry, ok := getOwnApprovedEntry(session, interaction, entryId)
+	if !ok {
+		return
+	}
+
+	err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Flags: discordgo.MessageFlagsEphemeral,
+			Files: []*discordgo.File{
+				{
+					Name:        fmt.Sprintf("parking-%s.ics", entry.ConfirmationCode),
+					ContentType: "text/calendar",
+					Reader:      bytes.NewReader(HistoryEntryICS(entry)),
+				},
+			},
+		},
+	})
+	if err != nil {
+		log.WithField("error", err).Error("Failed to attach calendar event")
+	}
+}
+
+// getOwnApprovedEntry finds an approved registration in the interacting user's history, responding with an error if it cannot.
+func getOwnApprovedEntry(session *discordgo.Session, interaction *discordgo.InteractionCreate, entryId string) (HistoryEntry, bool) {
+	userId, parseErr := strconv.Atoi(GetUser(interaction).ID)
+	if parseErr != nil {
+		HandleError(session, interaction, parseErr, "Error occurred while parsing user id")
+		return HistoryEntry{}, false
+	}
+
+	history := GetHistory(userId)
+	index := slices.IndexFunc(history, func(entry HistoryEntry) bool {
+		return entry.ID == entryId
+	})
+	if index == -1 {
+		HandleError(session, interaction, nil, ":x: This registration could not be found in your history.")
+		return HistoryEntry{}, false
+	}
+
+	if !history[index].Approved {
+		HandleError(session, interaction, nil, ":x: Only approved registrations can be downloaded.")
+		return HistoryEntry{}, false
+	}
+	return history[index], true
+}
+
+// ExportHistoryCommand exports every member's history from the command line.
+// Usage: export-history [-format csv|json] [-output path]
+func ExportHistoryCommand(args []string) {
+	flags := flag.NewFlagSet("export-history", flag.ExitOnError)
+	format := flags.String("format", "csv", "Export format (csv or json)")
+	output := flags.String("output", "", "File to write to (defaults to stdout)")
+	flags.Parse(args)
+
+	var writer io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			log.WithField("error", err).Fatal("Unable to create output file")
+		}
+		defer file.Close()
+		writer = file
+	}
+
+	entries := GetAllHistory()
+	if err := WriteHistory(writer, entries, *format); err != nil {
+		log.WithField("error", err).Fatal("Failed to export history")
+	}
+	log.WithFields(log.Fields{"count": len(entries), "format": *format}).Info("Exported history")
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html/template"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// FakeProperty is a complex served by the fake Register2Park server.
+type FakeProperty struct {
+	ID                uint        `json:"id"`
+	Key               string      `json:"key"`
+	Name              string      `json:"name"`
+	Address           string      `json:"address"` // Street, city and state, e.g. '6023 UTSA Blvd, San Antonio, TX'
+	Zip               string      `json:"zip"`
+	GuestCodes        []string    `json:"guestCodes,omitempty"`    // Codes accepted by the property; without any, none is required
+	ResidentProfileId uint        `json:"residentProfileId"`       // Sent back with registrations
+	Fields            []FakeField `json:"fields,omitempty"`        // Defaults to make, model and plate (confirmed)
+	Hidden            []string    `json:"hidden,omitempty"`        // Hidden inputs the form includes
+	ApprovalHours     int         `json:"approvalHours,omitempty"` // Defaults to 24
+	DeniedPlates      []string    `json:"deniedPlates,omitempty"`  // Plates always denied
+	PlateLimit        int         `json:"plateLimit,omitempty"`    // Registrations allowed per plate before it's denied, 0 for no limit
+}
+
+// FakeField is a field of a fake property's registration form.
+type FakeField struct {
+	ID    string `json:"id"`
+	Label string `json:"label"`
+}
+
+// FakeConfig configures the fake Register2Park server, and the failures it injects.
+type FakeConfig struct {
+	Properties    []FakeProperty
+	SessionExpiry time.Duration  // How long a session lasts after visiting '/' or '/index.php', 0 to not require sessions
+	Latency       time.Duration  // Added to every response
+	ErrorRate     float64        // Fraction of requests answered with a 503
+	Errors        map[string]int // Statuses to always answer requests to a path with
+	Seed          int64          // Seeds confirmation codes and injected errors, so runs can be repeated
+}
+
+var defaultFakeFields = []FakeField{
+	{ID: "vehicleMake", Label: "Make:"},
+	{ID: "vehicleModel", Label: "Model:"},
+	{ID: "vehicleLicensePlate", Label: "License Plate:"},
+	{ID: "vehicleLicensePlateConfirm", Label: "Confirm License Plate:"},
+}
+
+// DefaultFakeProperties mirror the property in samples/*.har, along with one that doesn't require a guest code.
+var DefaultFakeProperties = []FakeProperty{
+	{ID: 22167, Key: "678zv9zzylvw", Name: "Luxx, The", Address: "6023 UTSA Blvd, San Antonio, TX", Zip: "78249", GuestCodes: []string{"ABCDEFGH"}, ResidentProfileId: 1234},
+	{ID: 22168, Key: "fakeopen0001", Name: "Open Lot", Address: "1 Main St, San Antonio, TX", Zip: "78205", ResidentProfileId: 1235,
+		Fields: append(slices.Clone(defaultFakeFields), FakeField{ID: "vehicleApt", Label: "Apartment Number:"})},
+}
+
+// FakeServer imitates Register2Park for integration testing, serving HTML modeled on samples/*.har.
+type FakeServer struct {
+	config        FakeConfig
+	lock          sync.Mutex
+	random        *rand.Rand
+	sessions      map[string]time.Time // Session identifiers, by when they were last refreshed
+	registrations map[string]int       // Registrations per property and plate
+	vehicles      map[uint]uint        // The property each registered vehicle identifier was issued at
+	nextVehicle   uint
+}
+
+// NewFakeServer creates a fake server for the given configuration.
+func NewFakeServer(config FakeConfig) *FakeServer {
+	return &FakeServer{
+		config:        config,
+		random:        rand.New(rand.NewSource(config.Seed)),
+		sessions:      make(map[string]time.Time),
+		registrations: make(map[string]int),
+		vehicles:      make(map[uint]uint),
+		nextVehicle:   64647,
+	}
+}
+
+// StartFakeServer starts a fake server on a local port, which the caller must close.
+func StartFakeServer(config FakeConfig) *httptest.Server {
+	return httptest.NewServer(NewFakeServer(config))
+}
+
+func (server *FakeServer) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if server.config.Latency > 0 {
+		time.Sleep(server.config.Latency)
+	}
+	request.ParseForm()
+
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	if status, ok := server.config.Errors[request.URL.Path]; ok {
+		http.Error(writer, http.StatusText(status), status)
+		return
+	}
+	if server.config.ErrorRate > 0 && server.random.Float64() < server.config.ErrorRate {
+		http.Error(writer, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "text/html; charset=UTF-8")
+	switch request.URL.Path {
+	case "/", "/index.php":
+		server.refreshSession(writer, request)
+		fmt.Fprint(writer, "<!DOCTYPE html><html><head><title>Register2Park</title></head><body></body></html>")
+		return
+	}
+
+	// Without a live session, Register2Park's endpoints respond with nothing
+	if !server.validSession(request) {
+		return
+	}
+
+	switch request.URL.Path {
+	case "/register-get-properties-from-name":
+		query := strings.ToLower(request.PostForm.Get("propertyNameEntered"))
+		properties := slices.DeleteFunc(slices.Clone(server.config.Properties), func(property FakeProperty) bool {
+			return !strings.Contains(strings.ToLower(property.Name), query)
+		})
+		fakePropertiesTemplate.Execute(writer, properties)
+
+	case "/register-get-vehicle-form":
+		property, ok := server.property(request.PostForm.Get("propertyIdSelected"))
+		if !ok {
+			return
+		}
+		if len(property.GuestCodes) > 0 {
+			fakeGuestCodeTemplate.Execute(writer, property)
+		} else {
+			fakeVehicleFormTemplate.Execute(writer, property)
+		}
+
+	case "/register-get-vip-vehicle-form":
+		property, ok := server.property(request.PostForm.Get("propertyIdSelected"))
+		if !ok {
+			return
+		}
+		if !slices.Contains(property.GuestCodes, request.PostForm.Get("guestCode")) {
+			fmt.Fprint(writer, "guest-code")
+			return
+		}
+		fakeVehicleFormTemplate.Execute(writer, property)
+
+	case "/register-vehicle-vip-process":
+		server.register(writer, request)
+
+	case "/register-vehicle-confirmation-process":
+		vehicleId, _ := strconv.ParseUint(request.Form.Get("vehicleId"), 10, 64)
+		propertyId, issued := server.vehicles[uint(vehicleId)]
+		if !issued || strconv.FormatUint(uint64(propertyId), 10) != request.Form.Get("propertyId") || !strings.Contains(request.Form.Get("email"), "@") {
+			fmt.Fprint(writer, "error")
+			return
+		}
+		fmt.Fprint(writer, "ok")
+
+	default:
+		http.NotFound(writer, request)
+	}
+}
+
+// refreshSession starts a session if there isn't a live one, or extends it.
+func (server *FakeServer) refreshSession(writer http.ResponseWriter, request *http.Request) {
+	if cookie, err := request.Cookie("PHPSESSID"); err == nil && server.validSession(request) {
+		server.sessions[cookie.Value] = time.Now()
+		return
+	}
+
+	session := fmt.Sprintf("%026x", server.random.Int63())
+	server.sessions[session] = time.Now()
+	http.SetCookie(writer, &http.Cookie{Name: "PHPSESSID", Value: session, Path: "/"})
+}
+
+func (server *FakeServer) validSession(request *http.Request) bool {
+	if server.config.SessionExpiry == 0 {
+		return true
+	}
+	cookie, err := request.Cookie("PHPSESSID")
+	if err != nil {
+		return false
+	}
+	refreshed, ok := server.sessions[cookie.Value]
+	return ok && time.Since(refreshed) < server.config.SessionExpiry
+}
+
+func (server *FakeServer) property(id string) (FakeProperty, bool) {
+	for _, property := range server.config.Properties {
+		if strconv.FormatUint(uint64(property.ID), 10) == id {
+			return property, true
+		}
+	}
+	return FakeProperty{}, false
+}
+
+// register approves or denies a registration, as decided by the property's rules.
+func (server *FakeServer) register(writer http.ResponseWriter, request *http.Request) {
+	property, ok := server.property(request.PostForm.Get("propertyIdSelected"))
+	if !ok || request.PostForm.Get("residentProfileId") != strconv.FormatUint(uint64(property.ResidentProfileId), 10) {
+		fmt.Fprint(writer, "error")
+		return
+	}
+	for _, field := range property.fields() {
+		if strings.TrimSpace(request.PostForm.Get(field.ID)) == "" {
+			fmt.Fprint(writer, "error")
+			return
+		}
+	}
+
+	plate := strings.ToUpper(request.PostForm.Get("vehicleLicensePlate"))
+	key := fmt.Sprintf("%d:%s", property.ID, plate)
+	result := fakeResult{
+		Property:  property,
+		Plate:     plate,
+		Timestamp: time.Now().In(propertyTimezone()).Format("2006-01-02 03:04 PM"),
+		Hours:     property.ApprovalHours,
+	}
+	if result.Hours == 0 {
+		result.Hours = 24
+	}
+
+	denied := slices.ContainsFunc(property.DeniedPlates, func(denied string) bool {
+		return strings.EqualFold(denied, plate)
+	})
+	if denied || property.PlateLimit > 0 && server.registrations[key] >= property.PlateLimit {
+		fakeDeniedTemplate.Execute(writer, result)
+		return
+	}
+
+	server.registrations[key]++
+	server.nextVehicle++
+	server.vehicles[server.nextVehicle] = property.ID
+	result.VehicleId = server.nextVehicle
+
+	const alphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	code := make([]byte, 8)
+	for index := range code {
+		code[index] = alphabet[server.random.Intn(len(alphabet))]
+	}
+	result.ConfirmationCode = string(code)
+	fakeApprovedTemplate.Execute(writer, result)
+}
+
+func (property FakeProperty) fields() []FakeField {
+	if len(property.Fields) == 0 {
+		return defaultFakeFields
+	}
+	return property.Fields
+}
+
+type fakeResult struct {
+	Property         FakeProperty
+	Plate            string
+	Timestamp        string
+	Hours            int
+	VehicleId        uint
+	ConfirmationCode string
+}
+
+var fakeTemplateFuncs = template.FuncMap{
+	"fields": FakeProperty.fields,
+}
+
+var fakePropertiesTemplate = template.Must(template.New("properties").Parse(`		<div class="row">
+			<div class="col-xs-12 col-sm-10 col-sm-offset-1 col-lg-6 col-lg-offset-3 col-md-6 col-md-offset-3">
+				<div class="alert alert-danger" style="text-align: center;">Please select a matching property from below:</div>
+				<li class="divider"></li>
+			</div>
+		</div>
+		<div class="row">
+			<div class="col-xs-12 col-sm-12 col-sm-offset-1 col-lg-6 col-lg-offset-3 col-md-6 col-md-offset-3">
+				<form id="property-name-form">
+					<div class="form-group">
+{{- range .}}
+							<div class="property radio no-translation">
+								<label>
+									<input type="radio" name="property" class="property" value="{{.ID}}" style="margin-top: 23px;" data-source="parking-snap" data-locate-key="{{.Key}}">
+									<b>{{.Name}}</b><br>
+										{{.Address}} <br>
+									{{.Zip}}								</label>
+							</div>
+							<li class="divider"></li>
+{{- end}}
+					</div>
+					<button class="form-control btn btn-danger" type="button" id="confirmPropertySelection" disabled>Next</button>
+				</form>
+			</div>
+		</div>
+`))
+
+var fakeHeaderTemplate = `		<div class="row no-translation">
+			<div class="col-xs-12 col-sm-10 col-sm-offset-1 col-lg-6 col-lg-offset-3 col-md-6 col-md-offset-3" style="text-align: center;">
+				<h4 style="text-decoration: underline;">{{.Name}}</h4>
+				<div style="text-decoration: underline; font-size: 14px;">
+					{{.Address}}. {{.Zip}} <br>
+				</div>
+				<div class="divider"></div>
+			</div>
+		</div>
+`
+
+var fakeGuestCodeTemplate = template.Must(template.New("guest-code").Parse(fakeHeaderTemplate + `		<div class="row">
+			<div class="col-xs-12 col-sm-12 col-sm-offset-1 col-lg-6 col-lg-offset-3 col-md-6 col-md-offset-3">
+				<form id="property-name-form">
+					<div class="form-group">
+						<label for="accessCode">Guest Code:</label>
+						<input type="text" class="form-control" id="guestCode">
+					</div>
+					<button class="form-control btn btn-danger" type="button" id="propertyGuestCode">Next</button>
+				</form>
+			</div>
+		</div>
+`))
+
+var fakeVehicleFormTemplate = template.Must(template.New("vehicle-form").Funcs(fakeTemplateFuncs).Parse(fakeHeaderTemplate + `		<div class="row">
+			<div class="col-xs-12 col-sm-12 col-sm-offset-1 col-lg-6 col-lg-offset-3 col-md-6 col-md-offset-3">
+				<form id="property-name-form">
+{{- range .Hidden}}
+					<input type="hidden" id="{{.}}">
+{{- end}}
+{{- range fields .}}
+					<div class="form-group">
+						<label for="{{.ID}}">{{.Label}}</label>
+						<input type="text" class="form-control" id="{{.ID}}">
+					</div>
+{{- end}}
+					<button class="form-control btn btn-danger" type="button" id="vehicleInformationVIP" data-resident-profile-id="{{.ResidentProfileId}}">Next</button>
+				</form>
+			</div>
+		</div>
+`))
+
+var fakeApprovedTemplate = template.Must(template.New("approved").Parse(`                <div class="row">
+                    <div class="col-xs-12 col-sm-10 col-sm-offset-1 col-lg-6 col-lg-offset-3 col-md-6 col-md-offset-3">
+                        <div class="circle-success" style="text-align: center;">
+                            <div class="circle-inner">
+                                <h2 style="color: #FFF; font-weight: bold; margin: 0px;">
+                                    Approved<br>for {{.Hours}} hours                                </h2>
+                                <p style="color: #FFF; font-size: 12px; line-height: 17px;">
+                                    <strong>Registration Date/Time:</strong> {{.Timestamp}}<br>
+                                    <strong>Location:</strong> <span class="no-translation">{{.Property.Name}}</span><br>
+                                    <strong>License Plate:</strong> {{.Plate}}                                </p>
+                                <p style="color: #FFF; font-weight: bold; font-size: 14px;">
+                                    Confirmation Code:
+                                </p>
+                                <h3 style="color: #FFF; font-weight: bold; margin: 0px;">
+                                    {{.ConfirmationCode}}                                </h3>
+                            </div>
+                        </div>
+                        <p style="font-size: 18px; text-align: center;">
+                            The vehicle listed above is approved<br>to park in an authorized space.                        </p>
+                        <center>
+                            <button type="button" class="btn btn-primary" id="email-confirmation" data-vehicle-id="{{.VehicleId}}">
+                                E-Mail Confirmation
+                            </button>
+                        </center>
+                    </div>
+                </div>
+`))
+
+var fakeDeniedTemplate = template.Must(template.New("denied").Parse(`                <div class="row">
+                    <div class="col-xs-12 col-sm-10 col-sm-offset-1 col-lg-6 col-lg-offset-3 col-md-6 col-md-offset-3">
+                        <div class="circle-failure" style="text-align: center;">
+                            <div class="circle-inner">
+                                <h2 style="color: #FFF; font-weight: bold; margin: 0px;">Denied<br>Parking</h2>
+                                <p style="color: #FFF; font-size: 12px; line-height: 17px;">
+                                    <strong>Denied Date/Time:</strong> {{.Timestamp}}<br>
+                                    <strong>Location:</strong> <span class="no-translation">{{.Property.Name}}</span><br>
+                                    <strong>License Plate:</strong> {{.Plate}}                                </p>
+                            </div>
+                        </div>
+                        <p style="font-size: 18px; text-align: center;">The vehicle listed above<br>is unauthorized to park.</p>
+                    </div>
+                </div>
+`))
+
+// FakeR2PCommand runs a fake Register2Park server until interrupted. Point the bot at it with R2P_BASE_URL.
+func FakeR2PCommand(args []string) {
+	flags := flag.NewFlagSet("fake-r2p", flag.ExitOnError)
+	address := flags.String("addr", "127.0.0.1:8080", "Address to listen on")
+	propertiesPath := flags.String("properties", "", "JSON file listing the properties to serve (defaults to those in the samples)")
+	sessionExpiry := flags.Duration("session-expiry", 0, "How long sessions last, 0 to not require them")
+	latency := flags.Duration("latency", 0, "Delay added to every response")
+	errorRate := flags.Float64("error-rate", 0, "Fraction of requests answered with a 503")
+	errors := flags.String("errors", "", "Statuses to always answer paths with, e.g. '/register-vehicle-vip-process=500,/=503'")
+	seed := flags.Int64("seed", time.Now().UnixNano(), "Seed for confirmation codes and injected errors")
+	flags.Parse(args)
+
+	config := FakeConfig{
+		Properties:    DefaultFakeProperties,
+		SessionExpiry: *sessionExpiry,
+		Latency:       *latency,
+		ErrorRate:     *errorRate,
+		Errors:        make(map[string]int),
+		Seed:          *seed,
+	}
+
+	if *propertiesPath != "" {
+		contents, err := os.ReadFile(*propertiesPath)
+		if err != nil {
+			log.WithField("error", err).Fatal("Failed to read properties")
+		}
+		if err := json.Unmarshal(contents, &config.Properties); err != nil {
+			log.WithField("error", err).Fatal("Failed to parse properties")
+		}
+	}
+
+	for _, pair := range strings.FieldsFunc(*errors, func(r rune) bool { return r == ',' }) {
+		path, status, _ := strings.Cut(pair, "=")
+		code, err := strconv.Atoi(status)
+		if err != nil {
+			log.WithField("error", err).Fatalf("Invalid status for %s", path)
+		}
+		config.Errors[path] = code
+	}
+
+	listener, err := net.Listen("tcp", *address)
+	if err != nil {
+		log.WithField("error", err).Fatal("Failed to listen")
+	}
+	server := &httptest.Server{Listener: listener, Config: &http.Server{Handler: NewFakeServer(config)}}
+	server.Start()
+	defer server.Close()
+	log.WithFields(log.Fields{"url": server.URL, "properties": len(config.Properties)}).Info("Fake Register2Park server running, set R2P_BASE_URL to use it")
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+	<-stop
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+// startFake starts a fake Register2Park server and points a new session at it, as the bot would with R2P_BASE_URL.
+// The real client is restored once the test finishes.
+func startFake(t *testing.T, config FakeConfig) *httptest.Server {
+	t.Helper()
+
+	server := StartFakeServer(config)
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previousClient, previousURL := client, baseURL
+	client = &http.Client{Jar: jar, Transport: &RecordingTransport{}}
+	baseURL = server.URL
+	t.Cleanup(func() {
+		server.Close()
+		client, baseURL = previousClient, previousURL
+	})
+
+	reload()
+	return server
+}
+
+// fakeRegister registers a plate at a fake property, retrieving its form as /register does.
+func fakeRegister(t *testing.T, propertyId uint, guestCode string, plate string) (*RegistrationResult, error) {
+	t.Helper()
+
+	form := FetchForm(Capture{}, propertyId, guestCode)
+	if form.err != nil {
+		return nil, form.err
+	}
+	context, err := NewRegisterContext(form, propertyId, guestCode)
+	if err != nil {
+		t.Fatalf("failed to parse form: %v", err)
+	}
+
+	values := map[string]string{
+		"vehicleMake":                "Honda",
+		"vehicleModel":               "Pilot",
+		"vehicleLicensePlate":        plate,
+		"vehicleLicensePlateConfirm": plate,
+		"vehicleApt":                 "1234",
+	}
+	formParams := make(map[string]string, len(context.requiredFormKeys))
+	for _, key := range context.requiredFormKeys {
+		formParams[key] = values[key]
+	}
+	return RegisterVehicle(Capture{}, formParams, context.propertyId, context.residentId, context.hiddenKeys)
+}
+
+func TestFakeForms(t *testing.T) {
+	startFake(t, FakeConfig{Properties: DefaultFakeProperties})
+
+	if form := GetForm(Capture{}, 22167); !form.requireGuestCode {
+		t.Error("form was served without a guest code")
+	}
+	if form := GetVipForm(Capture{}, 22167, "WRONG123"); !form.requireGuestCode || form.err == nil {
+		t.Errorf("form = %+v, want the guest code rejected", form)
+	}
+
+	form := GetVipForm(Capture{}, 22167, "ABCDEFGH")
+	if form.err != nil {
+		t.Fatalf("failed to retrieve form: %v", form.err)
+	}
+	if form.propertyName != "Luxx, The" || form.residentProfileId != "1234" {
+		t.Errorf("form = %+v, want Luxx, The with resident profile 1234", form)
+	}
+
+	// Properties without a guest code serve the form straight away, including any extra fields
+	form = GetForm(Capture{}, 22168)
+	if form.err != nil || form.requireGuestCode {
+		t.Fatalf("form = %+v, want it served without a guest code", form)
+	}
+	if !slices.ContainsFunc(form.fields, func(field Field) bool { return field.id == "vehicleApt" }) {
+		t.Errorf("fields = %+v, want an apartment field", form.fields)
+	}
+}
+
+func TestFakeRegistration(t *testing.T) {
+	startFake(t, FakeConfig{Properties: DefaultFakeProperties, Seed: 1})
+
+	result, err := fakeRegister(t, 22167, "ABCDEFGH", "ABC1234")
+	if err != nil {
+		t.Fatalf("failed to register: %v", err)
+	}
+	if !result.success || len(result.confirmationCode) != 8 || result.vehicleId == "" {
+		t.Fatalf("result = %+v, want approved with a confirmation code and vehicle", result)
+	}
+	if result.expiry().Sub(result.timestamp) != 24*time.Hour {
+		t.Errorf("approved for %s, want 24h", result.expiry().Sub(result.timestamp))
+	}
+
+	sent, err := RegisterEmailConfirmation(Capture{}, "driver@example.com", result.vehicleId, "22167")
+	if err != nil || !sent {
+		t.Errorf("confirmation = %t (%v), want sent", sent, err)
+	}
+	if sent, err := RegisterEmailConfirmation(Capture{}, "driver@example.com", "1", "22167"); err == nil || sent {
+		t.Errorf("confirmation for an unknown vehicle = %t (%v), want an error", sent, err)
+	}
+
+	result, err = fakeRegister(t, 22168, "", "XYZ789")
+	if err != nil || !result.success {
+		t.Errorf("result = %+v (%v), want approved without a guest code", result, err)
+	}
+}
+
+func TestFakeDenials(t *testing.T) {
+	property := DefaultFakeProperties[0]
+	property.DeniedPlates = []string{"BANNED1"}
+	property.PlateLimit = 2
+	startFake(t, FakeConfig{Properties: []FakeProperty{property}})
+
+	tests := []struct {
+		plate    string
+		approved bool
+	}{
+		{"banned1", false},
+		{"ABC1234", true},
+		{"ABC1234", true},
+		{"ABC1234", false}, // The plate limit was reached
+		{"XYZ789", true},
+	}
+
+	for _, test := range tests {
+		result, err := fakeRegister(t, property.ID, "ABCDEFGH", test.plate)
+		if err != nil {
+			t.Fatalf("failed to register %s: %v", test.plate, err)
+		}
+		if result.success != test.approved {
+			t.Errorf("%s approved = %t, want %t", test.plate, result.success, test.approved)
+		}
+	}
+}
+
+func TestFakeSessionExpiry(t *testing.T) {
+	startFake(t, FakeConfig{Properties: DefaultFakeProperties, SessionExpiry: 50 * time.Millisecond})
+
+	if form := GetVipForm(Capture{}, 22167, "ABCDEFGH"); form.err != nil {
+		t.Fatalf("failed to retrieve form: %v", form.err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if form := GetVipForm(Capture{}, 22167, "ABCDEFGH"); form.err == nil {
+		t.Error("form was served after the session expired")
+	}
+
+	reload()
+	if form := GetVipForm(Capture{}, 22167, "ABCDEFGH"); form.err != nil {
+		t.Errorf("failed to retrieve form after reloading the session: %v", form.err)
+	}
+}
+
+func TestFakeLatency(t *testing.T) {
+	startFake(t, FakeConfig{Properties: DefaultFakeProperties, Latency: 50 * time.Millisecond})
+
+	started := time.Now()
+	if form := GetVipForm(Capture{}, 22167, "ABCDEFGH"); form.err != nil {
+		t.Fatalf("failed to retrieve form: %v", form.err)
+	}
+	if elapsed := time.Since(started); elapsed < 50*time.Millisecond {
+		t.Errorf("responded in %s, want at least 50ms", elapsed)
+	}
+}
+
+func TestFakeErrors(t *testing.T) {
+	startFake(t, FakeConfig{Properties: DefaultFakeProperties, Errors: map[string]int{
+		"/register-vehicle-vip-process":          http.StatusInternalServerError,
+		"/register-vehicle-confirmation-process": http.StatusTooManyRequests,
+	}})
+
+	// Forms are unaffected, but registering fails in a way that isn't retried, as it may have been made
+	_, err := fakeRegister(t, 22167, "ABCDEFGH", "ABC1234")
+	if err == nil || isTransient(err) {
+		t.Errorf("error = %v, want a failure that isn't retried", err)
+	}
+
+	if sent, err := RegisterEmailConfirmation(Capture{}, "driver@example.com", "64648", "22167"); err == nil || sent {
+		t.Errorf("confirmation = %t (%v), want an error", sent, err)
+	}
+}
+
+func TestFakeRateLimit(t *testing.T) {
+	startFake(t, FakeConfig{Properties: DefaultFakeProperties, Errors: map[string]int{
+		"/register-vehicle-vip-process": http.StatusTooManyRequests,
+	}})
+
+	_, err := fakeRegister(t, 22167, "ABCDEFGH", "ABC1234")
+	if !errors.Is(err, errUpstreamUnavailable) || !isTransient(err) {
+		t.Errorf("error = %v, want a rate limit that's retried", err)
+	}
+}
+
+func TestFakeErrorRate(t *testing.T) {
+	// Injected errors follow the seed, so the same requests fail every run
+	statuses := func() []int {
+		server := StartFakeServer(FakeConfig{Properties: DefaultFakeProperties, ErrorRate: 0.5, Seed: 42})
+		defer server.Close()
+
+		statuses := make([]int, 40)
+		for index := range statuses {
+			res, err := http.Get(server.URL + "/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			statuses[index] = res.StatusCode
+		}
+		return statuses
+	}
+
+	first := statuses()
+	if !slices.Equal(first, statuses()) {
+		t.Error("injected errors differ between runs with the same seed")
+	}
+
+	failed := 0
+	for _, status := range first {
+		switch status {
+		case http.StatusServiceUnavailable:
+			failed++
+		case http.StatusOK:
+		default:
+			t.Fatalf("status = %d, want 200 or 503", status)
+		}
+	}
+	if failed < 10 || failed > 30 {
+		t.Errorf("%d of %d requests failed, want about half", failed, len(first))
+	}
+}
+
+func TestFakeUnreachable(t *testing.T) {
+	server := startFake(t, FakeConfig{Properties: DefaultFakeProperties})
+
+	form := GetVipForm(Capture{}, 22167, "ABCDEFGH")
+	context, err := NewRegisterContext(form, 22167, "ABCDEFGH")
+	if err != nil {
+		t.Fatalf("failed to parse form: %v", err)
+	}
+	server.Close()
+
+	// Registrations that were never sent can't have been made, so they're retried
+	_, err = RegisterVehicle(Capture{}, map[string]string{"vehicleLicensePlate": "ABC1234"}, context.propertyId, context.residentId, context.hiddenKeys)
+	if !isTransient(err) {
+		t.Errorf("error = %v, want a failure that's retried", err)
+	}
+
+	// The form can't be retrieved either, which is reported rather than panicking
+	if form := GetForm(Capture{}, 22168); form.err == nil {
+		t.Error("form was retrieved from a closed server")
+	}
+}
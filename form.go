@@ -1,12 +1,15 @@
 package main
 
 import (
+	"strings"
+
 	"github.com/bwmarrin/discordgo"
 	log "github.com/sirupsen/logrus"
 )
 
 // FormToModalComponents converts the form requested into usable modal components.
-func FormToModalComponents(form GetFormResult) []discordgo.MessageComponent {
+// Any values provided (keyed by the field's id) are pre-filled into their respective inputs.
+func FormToModalComponents(form GetFormResult, values map[string]string) []discordgo.MessageComponent {
 	components := make([]discordgo.MessageComponent, 0, 3)
 
 	for _, field := range form.fields {
@@ -67,6 +70,8 @@ func FormToModalComponents(form GetFormResult) []discordgo.MessageComponent {
 			}
 		}
 
+		component.Value = values[component.CustomID]
+
 		// Each field is contained within its own row.
 		components = append(components, discordgo.ActionsRow{
 			Components: []discordgo.MessageComponent{
@@ -77,3 +82,21 @@ func FormToModalComponents(form GetFormResult) []discordgo.MessageComponent {
 
 	return components
 }
+
+// MissingFields returns the fields of the form that the given values do not provide.
+// Fields that are never presented to the user (such as the license plate confirmation) are not considered missing.
+func MissingFields(form GetFormResult, values map[string]string) []Field {
+	missing := make([]Field, 0, len(form.fields))
+
+	for _, field := range form.fields {
+		if field.id == "vehicleLicensePlateConfirm" {
+			continue
+		}
+
+		if strings.TrimSpace(values[field.id]) == "" {
+			missing = append(missing, field)
+		}
+	}
+
+	return missing
+}
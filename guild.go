@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/samber/lo"
+	"github.com/sirupsen/logrus"
+)
+
+// GuildConfig holds the per-guild settings managed by server administrators through /config.
+type GuildConfig struct {
+	Properties    []uint   `json:"properties"`              // The properties the guild's members register at
+	OnBehalfRoles []string `json:"onBehalfRoles,omitempty"` // Roles allowed to register vehicles on behalf of other members
+}
+
+// GetGuildConfig returns the configuration for a guild, or the defaults if none has been stored.
+func GetGuildConfig(guildId string) GuildConfig {
+	var config GuildConfig
+	loadJSON(fmt.Sprintf("guild:%s", guildId), &config)
+	return config
+}
+
+// StoreGuildConfig stores the configuration for a guild.
+func StoreGuildConfig(guildId string, config GuildConfig) {
+	storeJSON(fmt.Sprintf("guild:%s", guildId), config, 0)
+}
+
+// IsGuildAdmin returns true if the member invoking the interaction can manage the guild.
+func IsGuildAdmin(interaction *discordgo.InteractionCreate) bool {
+	return interaction.Member != nil && interaction.Member.Permissions&discordgo.PermissionManageServer != 0
+}
+
+// CanRegisterOnBehalf returns true if the member invoking the interaction may register vehicles for other members.
+// Server administrators always can, otherwise the member needs one of the roles allowed by the guild's configuration.
+func CanRegisterOnBehalf(interaction *discordgo.InteractionCreate) bool {
+	if interaction.GuildID == "" || interaction.Member == nil {
+		return false
+	}
+	if IsGuildAdmin(interaction) {
+		return true
+	}
+	config := GetGuildConfig(interaction.GuildID)
+	return lo.Some(interaction.Member.Roles, config.OnBehalfRoles)
+}
+
+var manageServerPermission int64 = discordgo.PermissionManageServer
+
+var ConfigCommandDefinition = &discordgo.ApplicationCommand{
+	Name:                     "config",
+	Description:              "Configure the bot for this server",
+	DefaultMemberPermissions: &manageServerPermission,
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "show",
+			Description: "Show this server's configuration",
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "add-property",
+			Description: "Add a complex this server's members register at",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:         discordgo.ApplicationCommandOptionString,
+					Name:         "location",
+					Description:  "The complex to add",
+					Required:     true,
+					Autocomplete: true,
+				},
+			},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "allow-on-behalf",
+			Description: "Allow a role to register vehicles on behalf of other members",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionRole,
+					Name:        "role",
+					Description: "The role to allow",
+					Required:    true,
+				},
+			},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "disallow-on-behalf",
+			Description: "Stop a role from registering vehicles on behalf of other members",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionRole,
+					Name:        "role",
+					Description: "The role to disallow",
+					Required:    true,
+				},
+			},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "remove-property",
+			Description: "Remove a complex from this server's configuration",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:         discordgo.ApplicationCommandOptionString,
+					Name:         "location",
+					Description:  "The complex to remove",
+					Required:     true,
+					Autocomplete: true,
+				},
+			},
+		},
+	},
+}
+
+func ConfigCommandHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+	log := logrus.WithFields(logrus.Fields{
+		"interaction": interaction.ID,
+		"user":        GetUser(interaction).ID,
+		"command":     "config",
+	})
+
+	if interaction.GuildID == "" || !IsGuildAdmin(interaction) {
+		HandleError(session, interaction, nil, ":x: Only server administrators can configure the bot.")
+		return
+	}
+
+	data := interaction.ApplicationCommandData()
+	subcommand := data.Options[0]
+
+	switch interaction.Type {
+
+	case discordgo.InteractionApplicationCommand:
+		config := GetGuildConfig(interaction.GuildID)
+		var content string
+
+		switch subcommand.Name {
+		case "add-property", "remove-property":
+			propertyId, parseErr := strconv.ParseUint(subcommand.Options[0].StringValue(), 10, 64)
+			if parseErr != nil || !LocationExists(int64(propertyId)) {
+				HandleError(session, interaction, parseErr, ":x: The location provided does not exist.")
+				return
+			}
+
+			if subcommand.Name == "add-property" {
+				if !slices.Contains(config.Properties, uint(propertyId)) {
+					config.Properties = append(config.Properties, uint(propertyId))
+				}
+				content = fmt.Sprintf("Added \"%s\" to this server's properties.", GetLocationName(uint(propertyId)))
+			} else {
+				config.Properties = lo.Without(config.Properties, uint(propertyId))
+				content = fmt.Sprintf("Removed \"%s\" from this server's properties.", GetLocationName(uint(propertyId)))
+			}
+			StoreGuildConfig(interaction.GuildID, config)
+			log.WithField("properties", config.Properties).Debug("Updated guild properties")
+
+		case "allow-on-behalf", "disallow-on-behalf":
+			// Only the role's ID is needed, so even roles deleted since can be disallowed
+			role := subcommand.Options[0].RoleValue(nil, "")
+
+			if subcommand.Name == "allow-on-behalf" {
+				if !slices.Contains(config.OnBehalfRoles, role.ID) {
+					config.OnBehalfRoles = append(config.OnBehalfRoles, role.ID)
+				}
+				content = fmt.Sprintf("Members with <@&%s> can now register vehicles on behalf of others.", role.ID)
+			} else {
+				config.OnBehalfRoles = lo.Without(config.OnBehalfRoles, role.ID)
+				content = fmt.Sprintf("Members with <@&%s> can no longer register vehicles on behalf of others.", role.ID)
+			}
+			StoreGuildConfig(interaction.GuildID, config)
+			log.WithField("roles", config.OnBehalfRoles).Debug("Updated guild on-behalf roles")
+
+		case "show":
+			content = DescribeGuildConfig(config)
+		}
+
+		err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: content,
+				Flags:   discordgo.MessageFlagsEphemeral,
+			},
+		})
+		if err != nil {
+			log.WithField("error", err).Error("Failed to respond to config command")
+		}
+
+	case discordgo.InteractionApplicationCommandAutocomplete:
+		focusedOption, _ := lo.Find(subcommand.Options, func(option *discordgo.ApplicationCommandInteractionDataOption) bool {
+			return option.Focused
+		})
+
+		var choices []*discordgo.ApplicationCommandOptionChoice
+		if focusedOption != nil && focusedOption.Name == "location" {
+			choices = LocationChoices(interaction, focusedOption.StringValue())
+		}
+
+		err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+			Data: &discordgo.InteractionResponseData{
+				Choices: choices,
+			},
+		})
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// DescribeGuildConfig summarizes a guild's configuration for display.
+func DescribeGuildConfig(config GuildConfig) string {
+	properties := "none"
+	if len(config.Properties) > 0 {
+		properties = strings.Join(lo.Map(config.Properties, func(propertyId uint, _ int) string {
+			return GetLocationName(propertyId)
+		}), ", ")
+	}
+
+	onBehalf := "server administrators only"
+	if len(config.OnBehalfRoles) > 0 {
+		onBehalf = "server administrators, " + strings.Join(lo.Map(config.OnBehalfRoles, func(roleId string, _ int) string {
+			return fmt.Sprintf("<@&%s>", roleId)
+		}), ", ")
+	}
+
+	return fmt.Sprintf("**Properties:** %s\n**Register on behalf:** %s", properties, onBehalf)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// harTimeFormat is the format of HAR timestamps, matching those in browser captures (see samples/*.har).
+const harTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// HAR is an HTTP Archive (version 1.2), as exported by browsers' network tools.
+type HAR struct {
+	Log HARLog `json:"log"`
+}
+
+type HARLog struct {
+	Version string     `json:"version"`
+	Creator HARCreator `json:"creator"`
+	Entries []HAREntry `json:"entries"`
+}
+
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// HAREntry is a single request and its response.
+type HAREntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HARTimings  `json:"timings"`
+	Time            float64     `json:"time"` // Total time taken, in milliseconds
+
+	// Custom fields (prefixed with '_' as the format requires) identifying what the request was made for
+	Interaction string `json:"_interaction,omitempty"`
+	Member      int    `json:"_member,omitempty"`
+	Location    uint   `json:"_location,omitempty"`
+}
+
+type HARRequest struct {
+	BodySize    int            `json:"bodySize"`
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Headers     []HARNameValue `json:"headers"`
+	Cookies     []HARNameValue `json:"cookies"`
+	QueryString []HARNameValue `json:"queryString"`
+	HeadersSize int            `json:"headersSize"`
+	PostData    *HARPostData   `json:"postData,omitempty"`
+}
+
+type HARResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Headers     []HARNameValue `json:"headers"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Content     HARContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+// HARNameValue is a header, cookie, query or form parameter.
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type HARPostData struct {
+	MimeType string         `json:"mimeType"`
+	Params   []HARNameValue `json:"params,omitempty"`
+	Text     string         `json:"text"`
+}
+
+type HARContent struct {
+	MimeType string `json:"mimeType"`
+	Size     int    `json:"size"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"` // Set to 'base64' for binary content
+}
+
+// HARTimings breaks down the time taken by an entry, in milliseconds. Only the wait is measured.
+type HARTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	SSL     float64 `json:"ssl"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// NewHAR creates an archive holding the given entries.
+func NewHAR(entries ...HAREntry) HAR {
+	return HAR{Log: HARLog{
+		Version: "1.2",
+		Creator: HARCreator{Name: "r2park-bot", Version: "1.0"},
+		Entries: entries,
+	}}
+}
+
+// WriteHAR writes an archive of the given entries as indented JSON.
+func WriteHAR(writer io.Writer, entries ...HAREntry) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	// Bodies are full of HTML, which browsers' exports don't escape
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(NewHAR(entries...))
+}
+
+// ReadHAR decodes an archive, such as those exported by browsers or written by WriteHAR.
+func ReadHAR(reader io.Reader) (HAR, error) {
+	var archive HAR
+	err := json.NewDecoder(reader).Decode(&archive)
+	return archive, err
+}
+
+// ReadHARFile decodes the archive within a file.
+func ReadHARFile(path string) (HAR, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return HAR{}, err
+	}
+	defer file.Close()
+	return ReadHAR(file)
+}
+
+// NewHAREntry records a request and its response, which took the given time after starting.
+// Bodies are restored after being read, and personal details are redacted (see Redact).
+func NewHAREntry(req *http.Request, res *http.Response, started time.Time, elapsed time.Duration) (HAREntry, error) {
+	milliseconds := float64(elapsed.Microseconds()) / 1000
+	entry := HAREntry{
+		StartedDateTime: started.Format(harTimeFormat),
+		Timings:         HARTimings{Wait: milliseconds},
+		Time:            milliseconds,
+	}
+
+	requestBody, err := peekRequestBody(req)
+	if err != nil {
+		return entry, err
+	}
+	entry.Request = HARRequest{
+		BodySize:    len(requestBody),
+		Method:      req.Method,
+		URL:         Redact(req.URL.String()),
+		HTTPVersion: req.Proto,
+		Headers:     harHeaders(req.Header),
+		Cookies:     harCookies(req.Cookies()),
+		QueryString: harParams(req.URL.Query()),
+		HeadersSize: -1,
+	}
+	if len(requestBody) > 0 {
+		entry.Request.PostData = harPostData(req.Header.Get("Content-Type"), string(requestBody))
+	}
+
+	if res == nil {
+		return entry, nil
+	}
+
+	responseBody, err := peekResponseBody(res)
+	if err != nil {
+		return entry, err
+	}
+	location, _ := res.Location()
+	entry.Response = HARResponse{
+		Status:      res.StatusCode,
+		StatusText:  strings.TrimPrefix(res.Status, strconv.Itoa(res.StatusCode)+" "),
+		HTTPVersion: res.Proto,
+		Headers:     harHeaders(res.Header),
+		Cookies:     harCookies(res.Cookies()),
+		Content: HARContent{
+			MimeType: res.Header.Get("Content-Type"),
+			Size:     len(responseBody),
+			Text:     Redact(string(responseBody)),
+		},
+		HeadersSize: -1,
+		BodySize:    len(responseBody),
+	}
+	if location != nil {
+		entry.Response.RedirectURL = location.String()
+	}
+
+	return entry, nil
+}
+
+func harHeaders(header http.Header) []HARNameValue {
+	headers := make([]HARNameValue, 0, len(header))
+	for name, values := range header {
+		for _, value := range values {
+			headers = append(headers, HARNameValue{Name: name, Value: Redact(value)})
+		}
+	}
+	return headers
+}
+
+func harCookies(cookies []*http.Cookie) []HARNameValue {
+	values := make([]HARNameValue, 0, len(cookies))
+	for _, cookie := range cookies {
+		values = append(values, HARNameValue{Name: cookie.Name, Value: redactValue(cookie.Name, cookie.Value)})
+	}
+	return values
+}
+
+func harParams(params url.Values) []HARNameValue {
+	values := make([]HARNameValue, 0, len(params))
+	for name, list := range params {
+		for _, value := range list {
+			values = append(values, HARNameValue{Name: name, Value: redactValue(name, value)})
+		}
+	}
+	return values
+}
+
+// harPostData records a request body, along with its parameters if it's a form.
+func harPostData(contentType string, body string) *HARPostData {
+	mimeType, _, _ := mime.ParseMediaType(contentType)
+	postData := &HARPostData{MimeType: mimeType, Text: Redact(body)}
+
+	if mimeType == "application/x-www-form-urlencoded" {
+		// Parameters are kept in the order they were sent, as browsers do
+		for _, pair := range strings.Split(body, "&") {
+			name, value, _ := strings.Cut(pair, "=")
+			name, _ = url.QueryUnescape(name)
+			value, _ = url.QueryUnescape(value)
+			postData.Params = append(postData.Params, HARNameValue{Name: name, Value: redactValue(name, value)})
+		}
+	}
+	return postData
+}
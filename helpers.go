@@ -1,6 +1,8 @@
 package main
 
 import (
+	cryptorand "crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -15,7 +17,8 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-const baseURL = "https://www.register2park.com"
+// baseURL is where Register2Park is reached, overridden by R2P_BASE_URL (e.g. to use a fake-r2p server).
+var baseURL = "https://www.register2park.com"
 
 // Builds a URL for the given path and parameters
 func BuildRequestWithBody(method string, path string, params map[string]string, body io.Reader) *http.Request {
@@ -60,7 +63,7 @@ func Plural(n int) string {
 
 // Sets User-Agent, Host, Content-Type, and Referer headers on the given request.
 // If contentType is empty, "application/x-www-form-urlencoded; charset=UTF-8" is used.
-// If referrer is empty, "https://www.register2park.com/register" is used.
+// If referrer is empty, the registration page ("/register") is used.
 // If xmlRequest is true, "X-Requested-With" is set to "XMLHttpRequest".
 func SetTypicalHeaders(req *http.Request, contentType *string, referrer *string, xmlRequest bool) {
 	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/113.0.0.0 Safari/537.36")
@@ -76,14 +79,24 @@ func SetTypicalHeaders(req *http.Request, contentType *string, referrer *string,
 	}
 
 	if referrer == nil {
-		req.Header.Set("Referer", "https://www.register2park.com/register")
+		req.Header.Set("Referer", baseURL+"/register")
 	} else {
 		req.Header.Set("Referer", *referrer)
 	}
 }
 
-// doRequest performs the given request and calls onRequest and onResponse.
-func doRequest(request *http.Request) (*http.Response, error) {
+// TruncateRunes shortens text to at most limit characters, without splitting any.
+func TruncateRunes(text string, limit int) string {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	return string(runes[:limit])
+}
+
+// doRequest performs the given request, labelled with a capture, and calls onRequest and onResponse.
+func doRequest(capture Capture, request *http.Request) (*http.Response, error) {
+	request = capture.label(request)
 	onRequest(request)
 	response, error := client.Do(request)
 	onResponse(response)
@@ -172,6 +185,38 @@ func GetFooterText() string {
 		strings.ToLower(CommitId[:7]))
 }
 
+// NewIdentifier returns a random identifier suitable for use within Redis keys and component custom IDs.
+func NewIdentifier() string {
+	buffer := make([]byte, 8)
+	if _, err := cryptorand.Read(buffer); err != nil {
+		log.WithField("error", err).Panic("Unable to generate identifier")
+	}
+	return hex.EncodeToString(buffer)
+}
+
+// SendDirectMessage sends a message to a user's direct messages.
+func SendDirectMessage(session *discordgo.Session, userId string, message *discordgo.MessageSend) error {
+	channel, err := session.UserChannelCreate(userId)
+	if err != nil {
+		return fmt.Errorf("failed to open direct message channel: %w", err)
+	}
+
+	_, err = session.ChannelMessageSendComplex(channel.ID, message)
+	if err != nil {
+		return fmt.Errorf("failed to send direct message: %w", err)
+	}
+	return nil
+}
+
+// GetUser returns the user that invoked an interaction.
+// Interactions within guilds carry the user within the member, while those in DMs carry it directly.
+func GetUser(interaction *discordgo.InteractionCreate) *discordgo.User {
+	if interaction.Member != nil {
+		return interaction.Member.User
+	}
+	return interaction.User
+}
+
 // HandleError(session, interaction, parse_err)
 func HandleError(session *discordgo.Session, interaction *discordgo.InteractionCreate, err error, message string) {
 	log.Errorf("%s (%v)", message, err)
@@ -185,10 +230,15 @@ func HandleError(session *discordgo.Session, interaction *discordgo.InteractionC
 			break
 		}
 
+		// limit to 256 characters
+		value := Redact(innerError.Error())
+		if len(value) > 256 {
+			value = value[:256]
+		}
+
 		innerErrorFields = append(innerErrorFields, &discordgo.MessageEmbedField{
-			Name: "Error",
-			// limit to 256 characters
-			Value: innerError.Error()[:256],
+			Name:  "Error",
+			Value: value,
 		})
 		innerCount += 1
 
@@ -200,19 +250,31 @@ func HandleError(session *discordgo.Session, interaction *discordgo.InteractionC
 		innerError = errors.Unwrap(innerError)
 	}
 
-	_, err = session.FollowupMessageCreate(interaction.Interaction, false, &discordgo.WebhookParams{
-		Embeds: []*discordgo.MessageEmbed{
-			{
-				Color:       0xff0000,
-				Title:       "An error has occurred.",
-				Description: message,
-				Footer: &discordgo.MessageEmbedFooter{
-					Text: GetFooterText(),
-				},
-				Fields: innerErrorFields,
-			},
+	embed := &discordgo.MessageEmbed{
+		Color:       0xff0000,
+		Title:       "An error has occurred.",
+		Description: message,
+		Footer: &discordgo.MessageEmbedFooter{
+			// The interaction ID lets administrators find the traffic recorded for it (see /capture)
+			Text: fmt.Sprintf("%s • Interaction %s", GetFooterText(), interaction.ID),
+		},
+		Fields: innerErrorFields,
+	}
+
+	// Respond directly if the interaction has not been responded to yet, otherwise follow up
+	err = session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Embeds: []*discordgo.MessageEmbed{embed},
+			Flags:  discordgo.MessageFlagsEphemeral,
 		},
 	})
+	if err != nil {
+		_, err = session.FollowupMessageCreate(interaction.Interaction, false, &discordgo.WebhookParams{
+			Embeds: []*discordgo.MessageEmbed{embed},
+			Flags:  discordgo.MessageFlagsEphemeral,
+		})
+	}
 
 	if err != nil {
 		log.WithField("error", err).Warn("Unable to provide error response to user.")
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/go-redis/redis"
+	"github.com/samber/lo"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	historyLimit    = 500 // The maximum number of entries kept per member
+	historyPageSize = 10  // The number of entries displayed per page of /history
+)
+
+// RecordRegistration appends the outcome of a registration attempt to a member's history.
+// Registrations made on the member's behalf (registeredBy is non-zero) are also appended to the registering member's history.
+func RecordRegistration(id string, member int, registeredBy int, propertyId uint, formParams map[string]string, result *RegistrationResult) HistoryEntry {
+	entry := HistoryEntry{
+		ID:               id,
+		Member:           member,
+		PropertyId:       propertyId,
+		PropertyName:     GetLocationName(propertyId),
+		Plate:            strings.ToUpper(formParams["vehicleLicensePlate"]),
+		Make:             formParams["vehicleMake"],
+		Model:            formParams["vehicleModel"],
+		Approved:         result.success,
+		ConfirmationCode: result.confirmationCode,
+		VehicleId:        result.vehicleId,
+		RegisteredAt:     result.timestamp,
+		RegisteredBy:     registeredBy,
+	}
+	if entry.RegisteredAt.IsZero() {
+		entry.RegisteredAt = time.Now()
+	}
+	if result.success {
+		entry.ExpiresAt = result.expiry()
+	}
+
+	encoded, err := sealJSON(entry)
+	if err != nil {
+		logrus.WithField("error", err).Error("Failed to encode history entry")
+		return entry
+	}
+
+	// Members who asked for their details not to be kept only receive the entry, for their receipt
+	neverStore := NeverStore(member)
+	if !neverStore {
+		appendHistory(member, encoded)
+	}
+	if registeredBy != 0 && registeredBy != member && !neverStore && !NeverStore(registeredBy) {
+		appendHistory(registeredBy, encoded)
+	}
+
+	// Approved registrations are also indexed by property until they expire, for /active all
+	if entry.Approved && !neverStore {
+		db.ZAdd(fmt.Sprintf("active:%d", propertyId), redis.Z{Score: float64(entry.ExpiresAt.Unix()), Member: encoded})
+	}
+
+	return entry
+}
+
+// appendHistory adds an encoded entry to the front of a member's history, dropping the oldest beyond the limit.
+func appendHistory(member int, encoded []byte) {
+	key := fmt.Sprintf("history:%d", member)
+	db.LPush(key, encoded)
+	db.LTrim(key, 0, historyLimit-1)
+}
+
+// GetHistory returns a member's registration history, newest first.
+func GetHistory(member int) []HistoryEntry {
+	key := fmt.Sprintf("history:%d", member)
+	entries := make([]HistoryEntry, 0)
+
+	for _, raw := range db.LRange(key, 0, -1).Val() {
+		var entry HistoryEntry
+		if err := unsealJSON(raw, &entry); err != nil {
+			logrus.WithFields(logrus.Fields{"error": err, "member": member}).Warn("Failed to decode history entry")
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+// HistoryFilter narrows down the entries displayed by /history.
+type HistoryFilter struct {
+	propertyId uint   // Only entries at this property, if non-zero
+	plate      string // Only entries for this license plate, if non-empty
+	outcome    string // Only "approved" or "denied" entries, if non-empty
+}
+
+// Matches returns true if the entry passes the filter.
+func (filter HistoryFilter) Matches(entry HistoryEntry) bool {
+	if filter.propertyId != 0 && entry.PropertyId != filter.propertyId {
+		return false
+	}
+	if filter.plate != "" && !strings.EqualFold(entry.Plate, filter.plate) {
+		return false
+	}
+	switch filter.outcome {
+	case "approved":
+		return entry.Approved
+	case "denied":
+		return !entry.Approved
+	}
+	return true
+}
+
+// encode converts the filter into a compact form suitable for component custom IDs.
+func (filter HistoryFilter) encode() string {
+	return fmt.Sprintf("%d:%s:%s", filter.propertyId, filter.plate, filter.outcome)
+}
+
+// decodeHistoryFilter parses a filter encoded with HistoryFilter.encode.
+func decodeHistoryFilter(encoded string) HistoryFilter {
+	parts := strings.SplitN(encoded, ":", 3)
+	for len(parts) < 3 {
+		parts = append(parts, "")
+	}
+
+	propertyId, _ := strconv.ParseUint(parts[0], 10, 64)
+	return HistoryFilter{propertyId: uint(propertyId), plate: parts[1], outcome: parts[2]}
+}
+
+var HistoryCommandDefinition = &discordgo.ApplicationCommand{
+	Name:        "history",
+	Description: "Look up your previous registrations",
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "view",
+			Description: "Browse your previous registrations",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:         discordgo.ApplicationCommandOptionString,
+					Name:         "location",
+					Description:  "Only show registrations at this complex",
+					Required:     false,
+					Autocomplete: true,
+				},
+				{
+					Type:         discordgo.ApplicationCommandOptionString,
+					Name:         "plate",
+					Description:  "Only show registrations for this license plate",
+					Required:     false,
+					Autocomplete: true,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "outcome",
+					Description: "Only show approved or denied registrations",
+					Required:    false,
+					Choices: []*discordgo.ApplicationCommandOptionChoice{
+						{Name: "Approved", Value: "approved"},
+						{Name: "Denied", Value: "denied"},
+					},
+				},
+			},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "export",
+			Description: "Download your registration history as a file",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "format",
+					Description: "The file format",
+					Required:    true,
+					Choices: []*discordgo.ApplicationCommandOptionChoice{
+						{Name: "CSV", Value: "csv"},
+						{Name: "JSON", Value: "json"},
+					},
+				},
+			},
+		},
+	},
+}
+
+func HistoryCommandHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+	log := logrus.WithFields(logrus.Fields{
+		"interaction": interaction.ID,
+		"user":        GetUser(interaction).ID,
+		"command":     "history",
+	})
+
+	userId, parseErr := strconv.Atoi(GetUser(interaction).ID)
+	if parseErr != nil {
+		HandleError(session, interaction, parseErr, "Error occurred while parsing user id")
+		return
+	}
+
+	subcommand := interaction.ApplicationCommandData().Options[0]
+
+	switch {
+
+	case interaction.Type == discordgo.InteractionApplicationCommand && subcommand.Name == "export":
+		format := subcommand.Options[0].StringValue()
+
+		var buffer bytes.Buffer
+		if err := WriteHistory(&buffer, GetHistory(userId), format); err != nil {
+			HandleError(session, interaction, err, "Failed to export history")
+			return
+		}
+
+		err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Flags: discordgo.MessageFlagsEphemeral,
+				Files: []*discordgo.File{
+					{
+						Name:        "history." + format,
+						ContentType: map[string]string{"csv": "text/csv", "json": "application/json"}[format],
+						Reader:      &buffer,
+					},
+				},
+			},
+		})
+		if err != nil {
+			log.WithField("error", err).Error("Failed to respond with history export")
+		}
+
+	case interaction.Type == discordgo.InteractionApplicationCommand:
+		var filter HistoryFilter
+		for _, option := range subcommand.Options {
+			switch option.Name {
+			case "location":
+				propertyId, _ := strconv.ParseUint(option.StringValue(), 10, 64)
+				filter.propertyId = uint(propertyId)
+			case "plate":
+				filter.plate = strings.ToUpper(option.StringValue())
+			case "outcome":
+				filter.outcome = option.StringValue()
+			}
+		}
+
+		err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: historyPage(userId, filter, 0),
+		})
+		if err != nil {
+			log.WithField("error", err).Error("Failed to respond with history")
+		}
+
+	case interaction.Type == discordgo.InteractionApplicationCommandAutocomplete:
+		var choices []*discordgo.ApplicationCommandOptionChoice
+
+		focusedOption, _ := lo.Find(subcommand.Options, func(option *discordgo.ApplicationCommandInteractionDataOption) bool {
+			return option.Focused
+		})
+
+		switch focusedOption.Name {
+		case "location":
+			choices = LocationChoices(interaction, focusedOption.StringValue())
+		case "plate":
+			choices = VehicleChoices(interaction, focusedOption.StringValue())
+		default:
+			log.WithFields(logrus.Fields{"focusedOption": focusedOption.Name, "focusedOption.value": focusedOption.Value}).Warn("Unhandled autocomplete option")
+		}
+
+		err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+			Data: &discordgo.InteractionResponseData{
+				Choices: choices,
+			},
+		})
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// HistoryPageHandler switches the page displayed by /history ('history-page:page:filter').
+func HistoryPageHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+	userId, parseErr := strconv.Atoi(GetUser(interaction).ID)
+	if parseErr != nil {
+		HandleError(session, interaction, parseErr, "Error occurred while parsing user id")
+		return
+	}
+
+	parts := strings.SplitN(interaction.MessageComponentData().CustomID, ":", 3)
+	if len(parts) < 3 {
+		HandleError(session, interaction, nil, "Error occurred while parsing history page")
+		return
+	}
+	page, parseErr := strconv.Atoi(parts[1])
+	if parseErr != nil {
+		HandleError(session, interaction, parseErr, "Error occurred while parsing history page")
+		return
+	}
+
+	err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: historyPage(userId, decodeHistoryFilter(parts[2]), page),
+	})
+	if err != nil {
+		logrus.WithField("error", err).Error("Failed to switch history page")
+	}
+}
+
+// historyPage builds the message displaying a single page of a member's filtered history.
+func historyPage(member int, filter HistoryFilter, page int) *discordgo.InteractionResponseData {
+	entries := lo.Filter(GetHistory(member), func(entry HistoryEntry, _ int) bool {
+		return filter.Matches(entry)
+	})
+
+	pages := max((len(entries)+historyPageSize-1)/historyPageSize, 1)
+	page = min(max(page, 0), pages-1)
+	start := page * historyPageSize
+	end := min(start+historyPageSize, len(entries))
+
+	embed := &discordgo.MessageEmbed{
+		Title: "Registration History",
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("Page %d of %d • %s", page+1, pages, GetFooterText()),
+		},
+		Fields: make([]*discordgo.MessageEmbedField, 0, historyPageSize),
+	}
+
+	if len(entries) == 0 {
+		embed.Description = "No registrations found."
+	} else {
+		embed.Description = fmt.Sprintf("%d registration%s found.", len(entries), Plural(len(entries)))
+	}
+
+	approvedOptions := make([]discordgo.SelectMenuOption, 0, historyPageSize)
+	for _, entry := range entries[start:end] {
+		embed.Fields = append(embed.Fields, HistoryEntryField(entry))
+
+		if entry.Approved {
+			approvedOptions = append(approvedOptions, discordgo.SelectMenuOption{
+				Label:       fmt.Sprintf("%s — %s", entry.PropertyName, entry.Plate),
+				Description: entry.RegisteredAt.Format("Jan 2, 2006 3:04 PM"),
+				Value:       entry.ID,
+			})
+		}
+	}
+
+	components := []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					Label:    "Previous",
+					Style:    discordgo.SecondaryButton,
+					CustomID: fmt.Sprintf("history-page:%d:%s", page-1, filter.encode()),
+					Disabled: page == 0,
+				},
+				discordgo.Button{
+					Label:    "Next",
+					Style:    discordgo.SecondaryButton,
+					CustomID: fmt.Sprintf("history-page:%d:%s", page+1, filter.encode()),
+					Disabled: page >= pages-1,
+				},
+			},
+		},
+	}
+
+	// Approved registrations on this page can be downloaded as calendar events or receipts
+	if len(approvedOptions) > 0 {
+		components = append(components, discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.SelectMenu{
+					MenuType:    discordgo.StringSelectMenu,
+					CustomID:    "calendar",
+					Placeholder: "Download a calendar event",
+					Options:     approvedOptions,
+				},
+			},
+		}, discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.SelectMenu{
+					MenuType:    discordgo.StringSelectMenu,
+					CustomID:    "receipt",
+					Placeholder: "Download a receipt",
+					Options:     approvedOptions,
+				},
+			},
+		})
+	}
+
+	return &discordgo.InteractionResponseData{
+		Embeds:     []*discordgo.MessageEmbed{embed},
+		Flags:      discordgo.MessageFlagsEphemeral,
+		Components: components,
+	}
+}
+
+// HistoryEntryField describes a single history entry as an embed field.
+func HistoryEntryField(entry HistoryEntry) *discordgo.MessageEmbedField {
+	status := ":white_check_mark:"
+	if !entry.Approved {
+		status = ":x:"
+	}
+
+	details := []string{fmt.Sprintf("<t:%d:f>", entry.RegisteredAt.Unix())}
+	if entry.Approved {
+		details = append(details, fmt.Sprintf("Code `%s`", entry.ConfirmationCode))
+		details = append(details, fmt.Sprintf("Expires <t:%d:R>", entry.ExpiresAt.Unix()))
+	} else {
+		details = append(details, "Denied")
+	}
+	if entry.RegisteredBy != 0 {
+		details = append(details, fmt.Sprintf("For <@%d> by <@%d>", entry.Member, entry.RegisteredBy))
+	}
+
+	return &discordgo.MessageEmbedField{
+		Name:  fmt.Sprintf("%s %s — %s", status, entry.PropertyName, entry.Plate),
+		Value: strings.Join(details, " • "),
+	}
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/samber/lo"
+	"github.com/sirupsen/logrus"
+)
+
+// vehicleFields are the form fields describing a vehicle, which visitors fill in themselves when redeeming an invite.
+var vehicleFields = []string{"vehicleMake", "vehicleModel", "vehicleLicensePlate", "vehicleLicensePlateConfirm"}
+
+var (
+	errInviteInvalid   = errors.New("invite is invalid")
+	errInviteExpired   = errors.New("invite has expired or was revoked")
+	errInviteExhausted = errors.New("invite has no uses left")
+)
+
+// inviteSecret returns the key invite tokens are signed with.
+// This is read from INVITE_SECRET, or generated once and kept in Redis so tokens survive restarts.
+var inviteSecret = sync.OnceValue(func() []byte {
+	if secret := os.Getenv("INVITE_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+
+	generated := make([]byte, 32)
+	if _, err := rand.Read(generated); err != nil {
+		logrus.WithField("error", err).Panic("Failed to generate invite secret")
+	}
+	db.SetNX("invite_secret", base64.StdEncoding.EncodeToString(generated), 0)
+
+	secret, err := base64.StdEncoding.DecodeString(db.Get("invite_secret").Val())
+	if err != nil || len(secret) == 0 {
+		logrus.WithField("error", err).Panic("Failed to load invite secret")
+	}
+	return secret
+})
+
+// signInvite returns the signature of an invite's identifier and expiry.
+func signInvite(id string, expires int64) string {
+	mac := hmac.New(sha256.New, inviteSecret())
+	fmt.Fprintf(mac, "%s.%d", id, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// InviteToken returns the token a visitor redeems an invite with ('id.expiry.signature').
+func InviteToken(invite Invite) string {
+	return fmt.Sprintf("%s.%d.%s", invite.ID, invite.Expires.Unix(), signInvite(invite.ID, invite.Expires.Unix()))
+}
+
+// ParseInviteToken verifies an invite token's signature and expiry, returning the invite it refers to.
+func ParseInviteToken(token string) (Invite, error) {
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 {
+		return Invite{}, errInviteInvalid
+	}
+
+	expires, parseErr := strconv.ParseInt(parts[1], 10, 64)
+	if parseErr != nil || !hmac.Equal([]byte(parts[2]), []byte(signInvite(parts[0], expires))) {
+		return Invite{}, errInviteInvalid
+	}
+	if time.Now().Unix() > expires {
+		return Invite{}, errInviteExpired
+	}
+
+	invite, ok := GetInvite(parts[0])
+	if !ok {
+		return Invite{}, errInviteExpired
+	}
+	return invite, nil
+}
+
+// StoreInvite stores an invite until it expires.
+func StoreInvite(invite Invite) {
+	ttl := time.Until(invite.Expires)
+	storeJSON(fmt.Sprintf("invite:%s", invite.ID), invite, ttl)
+	db.SAdd(fmt.Sprintf("invites:%d", invite.Resident), invite.ID)
+}
+
+// GetInvite returns an invite that has not yet expired or been revoked.
+func GetInvite(id string) (Invite, bool) {
+	var invite Invite
+	ok := loadJSON(fmt.Sprintf("invite:%s", id), &invite)
+	return invite, ok
+}
+
+// GetInvites returns a resident's outstanding invites, soonest to expire first.
+func GetInvites(resident int) []Invite {
+	key := fmt.Sprintf("invites:%d", resident)
+	invites := make([]Invite, 0)
+
+	for _, id := range db.SMembers(key).Val() {
+		invite, ok := GetInvite(id)
+		if !ok {
+			// The invite has expired, so the index no longer needs it
+			db.SRem(key, id)
+			continue
+		}
+		invites = append(invites, invite)
+	}
+
+	slices.SortFunc(invites, func(a, b Invite) int {
+		return a.Expires.Compare(b.Expires)
+	})
+	return invites
+}
+
+// RevokeInvite deletes an invite, so it can no longer be redeemed.
+func RevokeInvite(invite Invite) {
+	db.Del(fmt.Sprintf("invite:%s", invite.ID), fmt.Sprintf("invite_uses:%s", invite.ID))
+	db.SRem(fmt.Sprintf("invites:%d", invite.Resident), invite.ID)
+}
+
+// GetInviteUses returns the number of times an invite has been redeemed.
+func GetInviteUses(id string) int {
+	uses, _ := db.Get(fmt.Sprintf("invite_uses:%s", id)).Int()
+	return uses
+}
+
+// useInvite counts a redemption of an invite, failing if it has no uses left.
+// The number of uses including this one is returned.
+func useInvite(invite Invite) (int, error) {
+	key := fmt.Sprintf("invite_uses:%s", invite.ID)
+	uses := int(db.Incr(key).Val())
+	db.ExpireAt(key, invite.Expires)
+
+	if uses > invite.MaxUses {
+		db.Decr(key)
+		return uses - 1, errInviteExhausted
+	}
+	return uses, nil
+}
+
+// refundInvite gives back a use of an invite whose registration failed before Register2Park responded.
+func refundInvite(invite Invite) {
+	db.Decr(fmt.Sprintf("invite_uses:%s", invite.ID))
+}
+
+// notifyInviteUse tells the resident who issued an invite about a visitor's registration.
+func notifyInviteUse(invite Invite, uses int, visitor int, entry HistoryEntry) {
+	outcome := "was **denied**"
+	if entry.Approved {
+		outcome = fmt.Sprintf("was **approved** until <t:%d:f>", entry.ExpiresAt.Unix())
+	}
+
+	err := SendDirectMessage(session, strconv.Itoa(invite.Resident), &discordgo.MessageSend{
+		Content: fmt.Sprintf("<@%d> used your invite to register `%s` at \"%s\", which %s. The invite has been used %d of %d time%s.",
+			visitor, entry.Plate, entry.PropertyName, outcome, uses, invite.MaxUses, Plural(invite.MaxUses)),
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	})
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "user": invite.Resident, "invite": invite.ID}).Warn("Failed to notify resident of invite use")
+	}
+}
+
+var InviteCommandDefinition = &discordgo.ApplicationCommand{
+	Name:        "invite",
+	Description: "Invite a visitor to register at your complex, without sharing your guest code",
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "create",
+			Description: "Create an invite a visitor can redeem with /redeem",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:         discordgo.ApplicationCommandOptionString,
+					Name:         "location",
+					Description:  "The complex to invite the visitor to",
+					Required:     true,
+					Autocomplete: true,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "apartment",
+					Description: "Your apartment number, if the complex asks for one (defaults to the last one you used)",
+					Required:    false,
+					MaxLength:   5,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Name:        "uses",
+					Description: "How many registrations the invite allows (defaults to 1)",
+					Required:    false,
+					MinValue:    lo.ToPtr(1.0),
+					MaxValue:    10,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Name:        "hours",
+					Description: "How long the invite can be redeemed for (defaults to 24)",
+					Required:    false,
+					MinValue:    lo.ToPtr(1.0),
+					MaxValue:    168,
+				},
+			},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "list",
+			Description: "List your outstanding invites",
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "revoke",
+			Description: "Revoke an invite so it can no longer be redeemed",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:         discordgo.ApplicationCommandOptionString,
+					Name:         "invite",
+					Description:  "The invite to revoke",
+					Required:     true,
+					Autocomplete: true,
+				},
+			},
+		},
+	},
+}
+
+var dmPermission = true
+
+var RedeemCommandDefinition = &discordgo.ApplicationCommand{
+	Name:         "redeem",
+	Description:  "Register your vehicle with an invite from a resident",
+	DMPermission: &dmPermission,
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "invite",
+			Description: "The invite given to you by the resident",
+			Required:    true,
+		},
+	},
+}
+
+func InviteCommandHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+	log := logrus.WithFields(logrus.Fields{
+		"interaction": interaction.ID,
+		"user":        GetUser(interaction).ID,
+		"command":     "invite",
+	})
+
+	data := interaction.ApplicationCommandData()
+	subcommand := data.Options[0]
+
+	userId, parseErr := strconv.Atoi(GetUser(interaction).ID)
+	if parseErr != nil {
+		HandleError(session, interaction, parseErr, "Error occurred while parsing user id")
+		return
+	}
+
+	switch interaction.Type {
+
+	case discordgo.InteractionApplicationCommand:
+		switch subcommand.Name {
+		case "create":
+			options := lo.SliceToMap(subcommand.Options, func(option *discordgo.ApplicationCommandInteractionDataOption) (string, *discordgo.ApplicationCommandInteractionDataOption) {
+				return option.Name, option
+			})
+
+			locationId, _ := strconv.Atoi(options["location"].StringValue())
+			if !LocationExists(int64(locationId)) {
+				HandleError(session, interaction, nil, "The location provided does not exist.")
+				return
+			}
+
+			invite := Invite{
+				ID:         NewIdentifier(),
+				Resident:   userId,
+				PropertyId: uint(locationId),
+				Values:     map[string]string{},
+				MaxUses:    1,
+				Expires:    time.Now().Add(24 * time.Hour),
+			}
+			if option, ok := options["apartment"]; ok {
+				invite.Values["vehicleApt"] = option.StringValue()
+			} else if apartment, ok := GetApartment(uint(locationId), userId); ok {
+				invite.Values["vehicleApt"] = apartment
+			}
+			if option, ok := options["uses"]; ok {
+				invite.MaxUses = int(option.IntValue())
+			}
+			if option, ok := options["hours"]; ok {
+				invite.Expires = time.Now().Add(time.Duration(option.IntValue()) * time.Hour)
+			}
+
+			// Make sure visitors will actually be able to register with the resident's code
+			code, _ := GetCode(int64(locationId), userId)
+			form := FetchForm(NewCapture(interaction), uint(locationId), code)
+			if form.requireGuestCode {
+				HandleError(session, interaction, nil, ":x: This location requires a guest code. Set a valid one with `/code set` first.")
+				return
+			} else if form.err != nil {
+				HandleError(session, interaction, form.err, ":x: Failed to retrieve the registration form.")
+				return
+			}
+			if slices.ContainsFunc(form.fields, func(field Field) bool { return field.id == "vehicleApt" }) && invite.Values["vehicleApt"] == "" {
+				HandleError(session, interaction, nil, ":x: This location asks for an apartment number, so provide yours with the `apartment` option.")
+				return
+			}
+
+			StoreInvite(invite)
+			if apartment := invite.Values["vehicleApt"]; apartment != "" {
+				StoreApartment(invite.PropertyId, userId, apartment)
+			}
+			log.WithFields(logrus.Fields{"invite": invite.ID, "location": locationId, "uses": invite.MaxUses}).Debug("Created invite")
+
+			respondToInvite(session, interaction, fmt.Sprintf(
+				"Invite created for \"%s\", allowing %d registration%s until <t:%d:f>. Send your visitor this invite, which they redeem by messaging the bot `/redeem`:\n```\n%s\n```",
+				GetLocationName(invite.PropertyId), invite.MaxUses, Plural(invite.MaxUses), invite.Expires.Unix(), InviteToken(invite)))
+
+		case "list":
+			invites := GetInvites(userId)
+			if len(invites) == 0 {
+				respondToInvite(session, interaction, "You have no outstanding invites. Create one with `/invite create`.")
+				return
+			}
+
+			lines := lo.Map(invites, func(invite Invite, _ int) string {
+				return fmt.Sprintf("`%s` **%s**: used %d of %d, expires <t:%d:R>",
+					invite.ID, GetLocationName(invite.PropertyId), GetInviteUses(invite.ID), invite.MaxUses, invite.Expires.Unix())
+			})
+			respondToInvite(session, interaction, strings.Join(lines, "\n"))
+
+		case "revoke":
+			invite, ok := GetInvite(subcommand.Options[0].StringValue())
+			if !ok || invite.Resident != userId {
+				HandleError(session, interaction, nil, ":x: This invite has already expired or doesn't exist.")
+				return
+			}
+
+			RevokeInvite(invite)
+			respondToInvite(session, interaction, fmt.Sprintf("Your invite for \"%s\" has been revoked.", GetLocationName(invite.PropertyId)))
+		}
+
+	case discordgo.InteractionApplicationCommandAutocomplete:
+		var choices []*discordgo.ApplicationCommandOptionChoice
+
+		focusedOption, _ := lo.Find(subcommand.Options, func(option *discordgo.ApplicationCommandInteractionDataOption) bool {
+			return option.Focused
+		})
+		switch lo.FromPtr(focusedOption).Name {
+		case "location":
+			choices = LocationChoices(interaction, focusedOption.StringValue())
+		case "invite":
+			for _, invite := range GetInvites(userId) {
+				if len(choices) < 25 {
+					choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+						Name:  fmt.Sprintf("%s (%s, %d of %d used)", GetLocationName(invite.PropertyId), invite.ID, GetInviteUses(invite.ID), invite.MaxUses),
+						Value: invite.ID,
+					})
+				}
+			}
+		}
+
+		err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+			Data: &discordgo.InteractionResponseData{
+				Choices: choices,
+			},
+		})
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// respondToInvite responds to an /invite command with a hidden message.
+func respondToInvite(session *discordgo.Session, interaction *discordgo.InteractionCreate, content string) {
+	err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: content,
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		logrus.WithField("error", err).Error("Failed to respond to invite command")
+	}
+}
+
+// RedeemCommandHandler presents a visitor with the vehicle fields of the form for the property they were invited to.
+// The resident's stored guest code and non-vehicle values are used, without being shown to the visitor.
+func RedeemCommandHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+	invite, err := ParseInviteToken(interaction.ApplicationCommandData().Options[0].StringValue())
+	if err != nil {
+		HandleError(session, interaction, nil, fmt.Sprintf(":x: This %s.", err))
+		return
+	}
+	if GetInviteUses(invite.ID) >= invite.MaxUses {
+		HandleError(session, interaction, nil, fmt.Sprintf(":x: This %s.", errInviteExhausted))
+		return
+	}
+
+	code, _ := GetCode(int64(invite.PropertyId), invite.Resident)
+	form := FetchForm(NewCapture(interaction), invite.PropertyId, code)
+	if form.requireGuestCode {
+		HandleError(session, interaction, nil, ":x: The resident's guest code is no longer valid, so they'll need to update it before you can register.")
+		return
+	} else if form.err != nil {
+		HandleError(session, interaction, form.err, ":x: Failed to retrieve the registration form.")
+		return
+	}
+
+	context, err := NewRegisterContext(form, invite.PropertyId, code)
+	if err != nil {
+		HandleError(session, interaction, err, "Error occurred while parsing resident profile identifier")
+		return
+	}
+	context.redeem(invite)
+
+	// Only the vehicle fields (and anything the resident couldn't supply) are presented to the visitor
+	form.fields = lo.Filter(form.fields, func(field Field, _ int) bool {
+		return slices.Contains(vehicleFields, field.id) || invite.Values[field.id] == ""
+	})
+
+	PresentRegistration(session, interaction, context, form, nil, "")
+}
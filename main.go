@@ -15,27 +15,63 @@ import (
 
 var (
 	session            *discordgo.Session
-	commandDefinitions = []*discordgo.ApplicationCommand{RegisterCommandDefinition, CodeCommandDefinition}
+	commandDefinitions = []*discordgo.ApplicationCommand{RegisterCommandDefinition, CodeCommandDefinition, RemindersCommandDefinition, HistoryCommandDefinition, ActiveCommandDefinition, ConfigCommandDefinition, SharedCodeCommandDefinition, InviteCommandDefinition, RedeemCommandDefinition, RequestParkingCommandDefinition, EventCardCommandDefinition, RegisterBatchCommandDefinition, VehicleCommandDefinition, ForgetCommandDefinition, MyDataCommandDefinition, CaptureCommandDefinition}
 	commandHandlers    = map[string]func(s *discordgo.Session, i *discordgo.InteractionCreate){
-		"register": RegisterCommandHandler,
-		"code":     CodeCommandHandler,
+		"register":        RegisterCommandHandler,
+		"code":            CodeCommandHandler,
+		"reminders":       RemindersCommandHandler,
+		"history":         HistoryCommandHandler,
+		"active":          ActiveCommandHandler,
+		"config":          ConfigCommandHandler,
+		"shared-code":     SharedCodeCommandHandler,
+		"invite":          InviteCommandHandler,
+		"redeem":          RedeemCommandHandler,
+		"request-parking": RequestParkingCommandHandler,
+		"event-card":      EventCardCommandHandler,
+		"register-batch":  RegisterBatchCommandHandler,
+		"vehicle":         VehicleCommandHandler,
+		"forget":          ForgetCommandHandler,
+		"mydata":          MyDataCommandHandler,
+		"capture":         CaptureCommandHandler,
 	}
 	modalHandlers = map[string]func(s *discordgo.Session, i *discordgo.InteractionCreate){
-		"register": RegisterModalHandler,
+		"register":           RegisterModalHandler,
+		"register-elsewhere": RegisterElsewhereModalHandler,
+		"code-update":        CodeUpdateModalHandler,
+		"request-parking":    RequestParkingModalHandler,
+		"request-approve":    RequestApproveHandler,
 	}
-	db           *redis.Client
-	debugFlag    = flag.Bool("debug", false, "Enable debug logging")
-	levelPattern = regexp.MustCompile(`level=(error|fatal|panic|warning)`)
-)
-
-func init() {
-	flag.Parse()
-	if *debugFlag {
-		log.SetLevel(log.DebugLevel)
-	} else {
-		log.SetLevel(log.InfoLevel)
+	componentHandlers = map[string]func(s *discordgo.Session, i *discordgo.InteractionCreate){
+		"register-again":     RegisterAgainHandler,
+		"register-elsewhere": RegisterElsewhereHandler,
+		"register-location":  RegisterLocationHandler,
+		"auto-renew":         AutoRenewHandler,
+		"auto-renew-days":    AutoRenewDaysHandler,
+		"auto-renew-cancel":  AutoRenewCancelHandler,
+		"reminder-snooze":    ReminderSnoozeHandler,
+		"reminder-stop":      ReminderStopHandler,
+		"history-page":       HistoryPageHandler,
+		"calendar":           CalendarHandler,
+		"receipt":            ReceiptHandler,
+		"code-update":        CodeUpdateHandler,
+		"request-approve":    RequestApproveHandler,
+		"request-deny":       RequestDenyHandler,
+		"event-register":     EventRegisterHandler,
 	}
-}
+	jobHandlers = map[string]func(job *Job) error{
+		"renew":          RenewJobHandler,
+		"remind":         RemindJobHandler,
+		"revalidate":     RevalidateJobHandler,
+		"request-expire": RequestExpireJobHandler,
+		"event-close":    EventCloseJobHandler,
+	}
+	db              *redis.Client
+	debugFlag       = flag.Bool("debug", false, "Enable debug logging")
+	captureFlag     = flag.Bool("capture", false, "Record all Register2Park traffic as HAR (see /capture)")
+	replayFlag      = flag.String("replay", "", "Serve Register2Park responses from a HAR recording instead of contacting it")
+	unsafeDebugFlag = flag.Bool("unsafe-debug", false, "Disable redaction of personal details (plates, codes, emails, sessions) in logs and errors")
+	levelPattern    = regexp.MustCompile(`level=(error|fatal|panic|warning)`)
+)
 
 func Bot() {
 	// Setup the session parameters
@@ -70,6 +106,12 @@ func Bot() {
 	defer session.Close()
 	defer client.CloseIdleConnections() // HTTP client
 
+	// Start running scheduled jobs (renewals, etc.) in the background
+	ScheduleRevalidation()
+	stopScheduler := make(chan struct{})
+	go RunScheduler(stopScheduler)
+	defer close(stopScheduler)
+
 	// Setup command handlers
 	session.AddHandler(func(internalSession *discordgo.Session, interaction *discordgo.InteractionCreate) {
 		switch interaction.Type {
@@ -79,8 +121,7 @@ func Bot() {
 			}
 
 		case discordgo.InteractionModalSubmit:
-			id := interaction.ModalSubmitData().CustomID
-			handlerId := id[:strings.Index(id, ":")]
+			handlerId, _, _ := strings.Cut(interaction.ModalSubmitData().CustomID, ":")
 
 			if handler, ok := modalHandlers[handlerId]; ok {
 				handler(internalSession, interaction)
@@ -100,6 +141,17 @@ func Bot() {
 			// if err != nil {
 			// 	panic(err)
 			// }
+
+		case discordgo.InteractionMessageComponent:
+			// Component custom IDs follow the same 'handler:identifier' format as modals
+			handlerId, _, _ := strings.Cut(interaction.MessageComponentData().CustomID, ":")
+
+			if handler, ok := componentHandlers[handlerId]; ok {
+				handler(internalSession, interaction)
+			} else {
+				log.WithField("customId", interaction.MessageComponentData().CustomID).Warn("Unhandled message component")
+			}
+
 		default:
 			log.WithFields(
 				log.Fields{
@@ -117,7 +169,7 @@ func Bot() {
 	log.WithField("count", len(commandDefinitions)).Info("Registering commands")
 	registeredCommands := make([]*discordgo.ApplicationCommand, len(commandDefinitions))
 	for definitionIndex, commandDefinition := range commandDefinitions {
-		command, err := session.ApplicationCommandCreate(session.State.User.ID, os.Getenv("BOT_TARGET_GUILD"), commandDefinition)
+		command, err := session.ApplicationCommandCreate(session.State.User.ID, commandGuild(commandDefinition), commandDefinition)
 		log.WithField("command", commandDefinition.Name).Debug("Registering command")
 
 		if err != nil {
@@ -140,7 +192,7 @@ func Bot() {
 			"command": registeredCommand.Name,
 			"id":      registeredCommand.ID,
 		}).Debug("Removing command")
-		err := session.ApplicationCommandDelete(session.State.User.ID, os.Getenv("BOT_TARGET_GUILD"), registeredCommand.ID)
+		err := session.ApplicationCommandDelete(session.State.User.ID, registeredCommand.GuildID, registeredCommand.ID)
 		if err != nil {
 			log.Panicf("Cannot delete '%v' command: %v", registeredCommand.Name, err)
 		}
@@ -150,6 +202,15 @@ func Bot() {
 	defer log.Warn("Graceful shutdown complete")
 }
 
+// commandGuild returns the guild a command is registered within. Commands usable in DMs are registered globally,
+// as guild commands are only available to the guild's members.
+func commandGuild(definition *discordgo.ApplicationCommand) string {
+	if definition.DMPermission != nil && *definition.DMPermission {
+		return ""
+	}
+	return os.Getenv("BOT_TARGET_GUILD")
+}
+
 type OutputSplitter struct{}
 
 func (splitter *OutputSplitter) Write(p []byte) (n int, err error) {
@@ -161,18 +222,64 @@ func (splitter *OutputSplitter) Write(p []byte) (n int, err error) {
 }
 
 func main() {
-	log.SetFormatter(&log.TextFormatter{
+	// Flags are parsed here rather than in init, so tests can be built with their own
+	flag.Parse()
+	if *debugFlag {
+		log.SetLevel(log.DebugLevel)
+	} else {
+		log.SetLevel(log.InfoLevel)
+	}
+
+	log.SetFormatter(&RedactingFormatter{&log.TextFormatter{
 		TimestampFormat: "2006-01-02 15:04:05",
 		FullTimestamp:   true,
-	})
+	}})
 
 	log.SetOutput(&OutputSplitter{})
+	if *unsafeDebugFlag {
+		log.Warn("Redaction is disabled, personal details will be logged")
+	}
+
+	command := ""
+	args := flag.Args()
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	// Command line tools may write their output to stdout, so logs are kept out of the way
+	if command != "" && command != "bot" {
+		log.SetOutput(os.Stderr)
+	}
 
 	// Load environment variables
 	if err := godotenv.Load(); err != nil {
 		log.WithField("error", err).Warn("Failed to load .env file")
 	}
 
+	if override := os.Getenv("R2P_BASE_URL"); override != "" {
+		baseURL = strings.TrimSuffix(override, "/")
+		log.WithField("url", baseURL).Warn("Using an alternative Register2Park server")
+	}
+
+	// Replaying a recording and running a fake server are done entirely offline
+	switch command {
+	case "replay":
+		ReplayCommand(args[1:])
+		return
+	case "fake-r2p":
+		FakeR2PCommand(args[1:])
+		return
+	}
+
+	if *replayFlag != "" {
+		archive, err := ReadHARFile(*replayFlag)
+		if err != nil {
+			log.WithFields(log.Fields{"error": err, "path": *replayFlag}).Fatal("Failed to read recording")
+		}
+		client.Transport = &RecordingTransport{Base: NewReplayTransport(archive)}
+		log.WithField("path", *replayFlag).Warn("Replaying recorded responses, Register2Park will not be contacted")
+	}
+
 	opt := &redis.Options{
 		Addr:     os.Getenv("REDIS_HOST"),
 		Password: os.Getenv("REDIS_PASSWORD"),
@@ -185,17 +292,16 @@ func main() {
 	}
 	log.WithField("ping", ping_result).Info("Redis connection established")
 
-	command := ""
-	args := flag.Args()
-	if len(args) > 1 {
-		command = args[1]
-	}
-
 	log.WithField("command", command).Debug("Starting up")
 	switch command {
 	case "scan":
 		log.Info("Scanning...")
+	case "export-history":
+		ExportHistoryCommand(args[1:])
+	case "reencrypt":
+		ReencryptCommand(args[1:])
 	case "bot":
+		fallthrough
 	default:
 		log.Info("Starting bot...")
 		Bot()
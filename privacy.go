@@ -0,0 +1,493 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/samber/lo"
+	"github.com/sirupsen/logrus"
+)
+
+// SetNeverStore sets whether a member's details are kept after registering, such as their vehicles and history.
+func SetNeverStore(member int, enabled bool) {
+	key := fmt.Sprintf("never_store:%d", member)
+	if enabled {
+		db.Set(key, "1", 0)
+	} else {
+		db.Del(key)
+	}
+}
+
+// NeverStore returns true if a member has asked for their details not to be kept after registering.
+func NeverStore(member int) bool {
+	return db.Get(fmt.Sprintf("never_store:%d", member)).Val() == "1"
+}
+
+// CollectMemberData gathers everything stored about a member.
+func CollectMemberData(member int) MemberData {
+	data := MemberData{
+		Member:      member,
+		NeverStore:  NeverStore(member),
+		Apartments:  map[uint]string{},
+		Vehicles:    GetVehicles(member),
+		History:     GetHistory(member),
+		Submissions: map[string]Submission{},
+		Renewals:    GetRenewals(member),
+		Invites:     GetInvites(member),
+		Attendance:  map[string]string{},
+	}
+
+	if minutes, err := db.Get(fmt.Sprintf("reminder_lead:%d", member)).Int(); err == nil {
+		data.ReminderLead = &minutes
+	}
+
+	for location, code := range GetCodes(member) {
+		data.Codes = append(data.Codes, StoredCode{Location: location, Code: code, Status: GetCodeStatus(int64(location), member)})
+	}
+
+	for _, key := range scanKeys(fmt.Sprintf("apartment:*:%d", member)) {
+		var location uint
+		if _, err := fmt.Sscanf(key, "apartment:%d:", &location); err == nil {
+			data.Apartments[location], _ = GetApartment(location, member)
+		}
+	}
+
+	for _, key := range scanKeys("submission:*") {
+		var submission Submission
+		if loadJSON(key, &submission) && submission.Member == member {
+			data.Submissions[strings.TrimPrefix(key, "submission:")] = submission
+		}
+	}
+
+	for _, key := range scanKeys("shared_code:*") {
+		var shared SharedCode
+		if loadJSON(key, &shared) && shared.Owner == member {
+			data.SharedCodes = append(data.SharedCodes, shared)
+		}
+	}
+
+	for _, key := range scanKeys("shared_code_audit:*") {
+		var use SharedCodeUse
+		if _, err := fmt.Sscanf(strings.ReplaceAll(key, ":", " "), "shared_code_audit %s %d", &use.Guild, &use.PropertyId); err != nil {
+			continue
+		}
+		for _, codeUse := range GetCodeUses(use.Guild, use.PropertyId) {
+			if codeUse.Member == member {
+				use.CodeUse = codeUse
+				data.SharedCodeUses = append(data.SharedCodeUses, use)
+			}
+		}
+	}
+
+	for _, key := range scanKeys("parking_request:*") {
+		var request ParkingRequest
+		if loadJSON(key, &request) && (request.Guest == member || request.Resident == member) {
+			data.ParkingRequests = append(data.ParkingRequests, request)
+		}
+	}
+
+	for _, key := range scanKeys("event_card:*") {
+		var card EventCard
+		if loadJSON(key, &card) && card.Organizer == member {
+			data.EventCards = append(data.EventCards, card)
+		}
+	}
+
+	for _, key := range scanKeys("event_attendance:*") {
+		if outcome, err := db.HGet(key, strconv.Itoa(member)).Result(); err == nil {
+			data.Attendance[strings.TrimPrefix(key, "event_attendance:")] = outcome
+		}
+	}
+
+	captures, err := captureSink().Find(func(entry HAREntry) bool { return entry.Member == member })
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "member": member}).Warn("Failed to load recorded traffic")
+	}
+	data.Captures = captures
+
+	return data
+}
+
+// ForgetMember removes what is stored about a member at a location, or everywhere if the location is zero.
+// Vehicles and preferences aren't tied to a location, so they're only removed when forgetting everything.
+// The "never store" preference is kept regardless. Returns a description of each kind of data removed.
+func ForgetMember(member int, location uint) []string {
+	var removed []string
+	count := func(n int, singular string, plural string) {
+		if n > 0 {
+			removed = append(removed, fmt.Sprintf("%d %s", n, lo.Ternary(n == 1, singular, plural)))
+		}
+	}
+	atLocation := func(propertyId uint) bool {
+		return location == 0 || propertyId == location
+	}
+
+	codes := 0
+	for codeLocation := range GetCodes(member) {
+		if atLocation(codeLocation) {
+			RemoveCode(int64(codeLocation), member)
+			codes++
+		}
+	}
+	count(codes, "guest code", "guest codes")
+
+	apartments := 0
+	for _, key := range scanKeys(fmt.Sprintf("apartment:*:%d", member)) {
+		var apartmentLocation uint
+		if _, err := fmt.Sscanf(key, "apartment:%d:", &apartmentLocation); err == nil && atLocation(apartmentLocation) {
+			apartments += int(db.Del(key).Val())
+		}
+	}
+	count(apartments, "apartment number", "apartment numbers")
+
+	count(removeHistory(member, func(entry HistoryEntry) bool { return atLocation(entry.PropertyId) }), "history entry", "history entries")
+
+	// Registrations made on the member's behalf are also in their registrar's history, which shouldn't keep their plate
+	for _, key := range scanKeys("history:*") {
+		var registrar int
+		if _, err := fmt.Sscanf(key, "history:%d", &registrar); err != nil || registrar == member {
+			continue
+		}
+		removeHistory(registrar, func(entry HistoryEntry) bool {
+			return entry.Member == member && atLocation(entry.PropertyId)
+		})
+	}
+
+	submissions := 0
+	for _, key := range scanKeys("submission:*") {
+		var submission Submission
+		if loadJSON(key, &submission) && submission.Member == member && atLocation(submission.PropertyId) {
+			CancelReminder(strings.TrimPrefix(key, "submission:"))
+			submissions += int(db.Del(key).Val())
+		}
+	}
+	count(submissions, "recent registration and its reminder", "recent registrations and their reminders")
+
+	renewals := 0
+	for _, renewal := range GetRenewals(member) {
+		if atLocation(renewal.Submission.PropertyId) {
+			RemoveRenewal(renewal)
+			renewals++
+		}
+	}
+	count(renewals, "auto-renewal", "auto-renewals")
+
+	invites := 0
+	for _, invite := range GetInvites(member) {
+		if atLocation(invite.PropertyId) {
+			RevokeInvite(invite)
+			invites++
+		}
+	}
+	count(invites, "visitor invite", "visitor invites")
+
+	shares := 0
+	for _, key := range scanKeys("shared_code:*") {
+		var shared SharedCode
+		if loadJSON(key, &shared) && shared.Owner == member && atLocation(shared.PropertyId) {
+			RemoveSharedCode(shared.Guild, shared.PropertyId)
+			shares++
+		}
+	}
+	count(shares, "shared guest code", "shared guest codes")
+
+	uses := 0
+	for _, key := range scanKeys("shared_code_audit:*") {
+		var guild string
+		var propertyId uint
+		if _, err := fmt.Sscanf(strings.ReplaceAll(key, ":", " "), "shared_code_audit %s %d", &guild, &propertyId); err != nil || !atLocation(propertyId) {
+			continue
+		}
+		for _, raw := range db.LRange(key, 0, -1).Val() {
+			var use CodeUse
+			if json.Unmarshal([]byte(raw), &use) == nil && use.Member == member {
+				uses += int(db.LRem(key, 0, raw).Val())
+			}
+		}
+	}
+	count(uses, "use of a shared guest code", "uses of shared guest codes")
+
+	requests := 0
+	for _, key := range scanKeys("parking_request:*") {
+		var request ParkingRequest
+		if loadJSON(key, &request) && (request.Guest == member || request.Resident == member) && atLocation(request.PropertyId) {
+			if _, ok := claimParkingRequest(request.ID); ok {
+				requests++
+			}
+		}
+	}
+	count(requests, "parking request", "parking requests")
+
+	cards := 0
+	for _, key := range scanKeys("event_card:*") {
+		var card EventCard
+		if loadJSON(key, &card) && card.Organizer == member && atLocation(card.PropertyId) {
+			if err := UpdateEventCard(card, true); err != nil {
+				logrus.WithFields(logrus.Fields{"error": err, "event": card.ID}).Warn("Failed to close forgotten event card")
+			}
+			RemoveEventCard(card.ID)
+			cards++
+		}
+	}
+	count(cards, "event card", "event cards")
+
+	attendance := 0
+	for _, key := range scanKeys("event_attendance:*") {
+		card, ok := GetEventCard(strings.TrimPrefix(key, "event_attendance:"))
+		if location == 0 || (ok && atLocation(card.PropertyId)) {
+			attendance += int(db.HDel(key, strconv.Itoa(member)).Val())
+		}
+	}
+	count(attendance, "event attendance", "event attendances")
+
+	// Traffic recorded without a location is only removed when forgetting everything
+	captures, err := captureSink().Remove(func(entry HAREntry) bool {
+		return entry.Member == member && atLocation(entry.Location)
+	})
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "member": member}).Warn("Failed to remove recorded traffic")
+	}
+	count(captures, "recorded request", "recorded requests")
+
+	if location == 0 {
+		vehicles := len(GetVehicles(member))
+		db.Del(fmt.Sprintf("vehicles:%d", member))
+		count(vehicles, "saved vehicle", "saved vehicles")
+
+		count(int(db.Del(fmt.Sprintf("reminder_lead:%d", member)).Val()), "reminder preference", "reminder preferences")
+	}
+
+	return removed
+}
+
+// removeHistory removes the entries of a member's history that match, along with their place in the /active index.
+// Returns the number of entries removed.
+func removeHistory(member int, matches func(entry HistoryEntry) bool) int {
+	key := fmt.Sprintf("history:%d", member)
+	removed := 0
+
+	for _, raw := range db.LRange(key, 0, -1).Val() {
+		var entry HistoryEntry
+		if err := unsealJSON(raw, &entry); err != nil || !matches(entry) {
+			continue
+		}
+		removed += int(db.LRem(key, 0, raw).Val())
+
+		// Entries registered on someone else's behalf remain theirs
+		if entry.Member == member {
+			removeActive(entry)
+		}
+	}
+	return removed
+}
+
+// removeActive removes a registration from its property's /active index.
+// Entries are matched by their ID, as re-encryption means the stored value may differ from the one in history.
+func removeActive(entry HistoryEntry) {
+	key := fmt.Sprintf("active:%d", entry.PropertyId)
+	for _, raw := range db.ZRange(key, 0, -1).Val() {
+		var active HistoryEntry
+		if unsealJSON(raw, &active) == nil && active.ID == entry.ID && active.Member == entry.Member {
+			db.ZRem(key, raw)
+		}
+	}
+}
+
+var ForgetCommandDefinition = &discordgo.ApplicationCommand{
+	Name:        "forget",
+	Description: "Delete what's stored about you",
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "location",
+			Description: "Delete your codes, history, reminders & renewals at one location",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:         discordgo.ApplicationCommandOptionString,
+					Name:         "location",
+					Description:  "The complex to forget",
+					Required:     true,
+					Autocomplete: true,
+				},
+			},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "all",
+			Description: "Delete everything stored about you, including your saved vehicles",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Name:        "confirm",
+					Description: "Confirm that everything should be deleted, as this can't be undone",
+					Required:    true,
+				},
+			},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "never-store",
+			Description: "Choose whether your vehicles, history & recent registrations are kept after registering",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Name:        "enabled",
+					Description: "True to stop keeping your details",
+					Required:    true,
+				},
+			},
+		},
+	},
+}
+
+func ForgetCommandHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+	log := logrus.WithFields(logrus.Fields{
+		"interaction": interaction.ID,
+		"user":        GetUser(interaction).ID,
+		"command":     "forget",
+	})
+
+	userId, parseErr := strconv.Atoi(GetUser(interaction).ID)
+	if parseErr != nil {
+		HandleError(session, interaction, parseErr, "Error occurred while parsing user id")
+		return
+	}
+
+	subcommand := interaction.ApplicationCommandData().Options[0]
+
+	switch interaction.Type {
+
+	case discordgo.InteractionApplicationCommand:
+		var content string
+
+		switch subcommand.Name {
+		case "location", "all":
+			var location uint
+			scope := "anywhere"
+			if subcommand.Name == "location" {
+				propertyId, parseErr := strconv.ParseUint(subcommand.Options[0].StringValue(), 10, 64)
+				if parseErr != nil || !LocationExists(int64(propertyId)) {
+					HandleError(session, interaction, parseErr, ":x: The location provided does not exist.")
+					return
+				}
+				location = uint(propertyId)
+				scope = fmt.Sprintf("at \"%s\"", GetLocationName(location))
+			} else if !subcommand.Options[0].BoolValue() {
+				HandleError(session, interaction, nil, ":x: Nothing was deleted, as it wasn't confirmed.")
+				return
+			}
+
+			// Forgetting means scanning much of the database, which can take longer than an interaction allows
+			err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+				Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+				Data: &discordgo.InteractionResponseData{
+					Flags: discordgo.MessageFlagsEphemeral,
+				},
+			})
+			if err != nil {
+				log.WithField("error", err).Error("Failed to defer forget command")
+				return
+			}
+
+			removed := ForgetMember(userId, location)
+			log.WithFields(logrus.Fields{"location": location, "removed": removed}).Info("Forgot member data")
+
+			if len(removed) == 0 {
+				content = fmt.Sprintf("Nothing was stored about you %s.", scope)
+			} else {
+				content = fmt.Sprintf(":white_check_mark: Deleted everything stored about you %s:\n- %s", scope, strings.Join(removed, "\n- "))
+			}
+
+			if _, err := session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{Content: &content}); err != nil {
+				log.WithField("error", err).Error("Failed to respond to forget command")
+			}
+			return
+
+		case "never-store":
+			enabled := subcommand.Options[0].BoolValue()
+			SetNeverStore(userId, enabled)
+			if enabled {
+				content = "Your vehicles, history and registrations will no longer be kept after registering. Guest codes you set with `/code set` are still stored. Use `/forget all` to delete what's already stored."
+			} else {
+				content = "Your vehicles, history and registrations will be kept after registering again."
+			}
+		}
+
+		err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: content,
+				Flags:   discordgo.MessageFlagsEphemeral,
+			},
+		})
+		if err != nil {
+			log.WithField("error", err).Error("Failed to respond to forget command")
+		}
+
+	case discordgo.InteractionApplicationCommandAutocomplete:
+		var choices []*discordgo.ApplicationCommandOptionChoice
+		if len(subcommand.Options) > 0 && subcommand.Options[0].Focused && subcommand.Options[0].Name == "location" {
+			choices = LocationChoices(interaction, subcommand.Options[0].StringValue())
+		}
+
+		err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+			Data: &discordgo.InteractionResponseData{
+				Choices: choices,
+			},
+		})
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+var MyDataCommandDefinition = &discordgo.ApplicationCommand{
+	Name:        "mydata",
+	Description: "Download everything stored about you",
+}
+
+func MyDataCommandHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+	userId, parseErr := strconv.Atoi(GetUser(interaction).ID)
+	if parseErr != nil {
+		HandleError(session, interaction, parseErr, "Error occurred while parsing user id")
+		return
+	}
+
+	// Collecting everything means scanning much of the database, which can take a while
+	err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Flags: discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		logrus.WithField("error", err).Error("Failed to defer member data export")
+		return
+	}
+
+	encoded, err := json.MarshalIndent(CollectMemberData(userId), "", "  ")
+	if err != nil {
+		HandleError(session, interaction, err, "Failed to export your data")
+		return
+	}
+
+	content := "Here's everything stored about you. Delete it with `/forget`."
+	_, err = session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{
+		Content: &content,
+		Files: []*discordgo.File{
+			{
+				Name:        "mydata.json",
+				ContentType: "application/json",
+				Reader:      bytes.NewReader(encoded),
+			},
+		},
+	})
+	if err != nil {
+		logrus.WithField("error", err).Error("Failed to respond with member data")
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jung-kurt/gofpdf"
+	log "github.com/sirupsen/logrus"
+	"github.com/skip2/go-qrcode"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	receiptWidth   = 600
+	receiptHeight  = 860
+	receiptMargin  = 40
+	receiptQRSize  = 240
+	receiptTimeFmt = "Jan 2, 2006 3:04 PM MST"
+)
+
+var (
+	receiptGreen = color.RGBA{R: 0x2e, G: 0x9e, B: 0x4f, A: 0xff}
+	receiptGray  = color.RGBA{R: 0x55, G: 0x55, B: 0x55, A: 0xff}
+)
+
+// receiptFonts holds the fonts used to draw receipt images, parsed once on first use.
+// Parsed fonts can be shared, unlike the faces drawn with them, which are created for each receipt.
+var receiptFonts = sync.OnceValues(func() (map[string]*opentype.Font, error) {
+	fonts := map[string]*opentype.Font{}
+	for name, ttf := range map[string][]byte{"regular": goregular.TTF, "bold": gobold.TTF} {
+		parsed, err := opentype.Parse(ttf)
+		if err != nil {
+			return nil, err
+		}
+		fonts[name] = parsed
+	}
+	return fonts, nil
+})
+
+// receiptFaces creates the font faces used to draw a receipt image, keyed by their use.
+// Faces aren't safe for concurrent use, so each receipt has its own.
+func receiptFaces() (map[string]font.Face, error) {
+	fonts, err := receiptFonts()
+	if err != nil {
+		return nil, err
+	}
+
+	faces := map[string]font.Face{}
+	for name, spec := range map[string]struct {
+		font string
+		size float64
+	}{
+		"title":   {"bold", 40},
+		"heading": {"bold", 22},
+		"label":   {"regular", 16},
+		"value":   {"bold", 20},
+		"code":    {"bold", 34},
+	} {
+		face, err := opentype.NewFace(fonts[spec.font], &opentype.FaceOptions{Size: spec.size, DPI: 72, Hinting: font.HintingFull})
+		if err != nil {
+			return nil, err
+		}
+		faces[name] = face
+	}
+	return faces, nil
+}
+
+// receiptLine is a single labelled value displayed on a receipt.
+type receiptLine struct {
+	label string
+	value string
+}
+
+// receiptLines returns the details displayed on a receipt for an approved registration.
+func receiptLines(entry HistoryEntry) []receiptLine {
+	vehicle := strings.TrimSpace(entry.Make + " " + entry.Model)
+	if vehicle == "" {
+		vehicle = "—"
+	}
+
+	return []receiptLine{
+		{"License Plate", entry.Plate},
+		{"Vehicle", vehicle},
+		{"Registered", entry.RegisteredAt.In(propertyTimezone()).Format(receiptTimeFmt)},
+		{"Expires", entry.ExpiresAt.In(propertyTimezone()).Format(receiptTimeFmt)},
+	}
+}
+
+// receiptAddress returns the address of the property a registration was made at, if known.
+func receiptAddress(entry HistoryEntry) string {
+	if location, ok := cachedLocationsMap[entry.PropertyId]; ok {
+		return location.address
+	}
+	return ""
+}
+
+// RenderReceiptPNG draws a receipt card for an approved registration, including a QR code of the confirmation code.
+func RenderReceiptPNG(entry HistoryEntry) ([]byte, error) {
+	faces, err := receiptFaces()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load receipt fonts: %w", err)
+	}
+	defer func() {
+		for _, face := range faces {
+			face.Close()
+		}
+	}()
+
+	canvas := image.NewRGBA(image.Rect(0, 0, receiptWidth, receiptHeight))
+	draw.Draw(canvas, canvas.Bounds(), image.White, image.Point{}, draw.Src)
+
+	// Header band
+	draw.Draw(canvas, image.Rect(0, 0, receiptWidth, 110), image.NewUniform(receiptGreen), image.Point{}, draw.Src)
+
+	text := func(face string, fill color.Color, x int, y int, value string) {
+		drawer := &font.Drawer{Dst: canvas, Src: image.NewUniform(fill), Face: faces[face], Dot: fixed.P(x, y)}
+		drawer.DrawString(value)
+	}
+	centered := func(face string, fill color.Color, y int, value string) {
+		width := font.MeasureString(faces[face], value).Ceil()
+		text(face, fill, (receiptWidth-width)/2, y, value)
+	}
+
+	centered("title", color.White, 70, "PARKING APPROVED")
+
+	y := 160
+	text("heading", color.Black, receiptMargin, y, entry.PropertyName)
+	if address := receiptAddress(entry); address != "" {
+		y += 26
+		text("label", receiptGray, receiptMargin, y, address)
+	}
+
+	y += 50
+	for _, line := range receiptLines(entry) {
+		text("label", receiptGray, receiptMargin, y, line.label)
+		text("value", color.Black, receiptMargin+170, y, line.value)
+		y += 36
+	}
+
+	y += 30
+	centered("label", receiptGray, y, "Confirmation Code")
+	y += 42
+	centered("code", receiptGreen, y, entry.ConfirmationCode)
+
+	qr, err := qrcode.New(entry.ConfirmationCode, qrcode.Medium)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode QR code: %w", err)
+	}
+	qrImage := qr.Image(receiptQRSize)
+	qrOrigin := image.Point{X: (receiptWidth - receiptQRSize) / 2, Y: y + 20}
+	draw.Draw(canvas, image.Rectangle{Min: qrOrigin, Max: qrOrigin.Add(image.Point{X: receiptQRSize, Y: receiptQRSize})}, qrImage, image.Point{}, draw.Src)
+
+	var buffer bytes.Buffer
+	if err := png.Encode(&buffer, canvas); err != nil {
+		return nil, fmt.Errorf("failed to encode receipt image: %w", err)
+	}
+	return buffer.Bytes(), nil
+}
+
+// RenderReceiptPDF lays out a single-page receipt for an approved registration, including a QR code of the confirmation code.
+func RenderReceiptPDF(entry HistoryEntry) ([]byte, error) {
+	qrPNG, err := qrcode.Encode(entry.ConfirmationCode, qrcode.Medium, 512)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode QR code: %w", err)
+	}
+
+	pdf := gofpdf.New("P", "mm", "A5", "")
+	pdf.SetTitle(fmt.Sprintf("Parking Receipt %s", entry.ConfirmationCode), true)
+	pdf.SetMargins(12, 12, 12)
+	pdf.AddPage()
+	translate := pdf.UnicodeTranslatorFromDescriptor("")
+	pageWidth, _ := pdf.GetPageSize()
+	contentWidth := pageWidth - 24
+
+	// Header band
+	pdf.SetFillColor(int(receiptGreen.R), int(receiptGreen.G), int(receiptGreen.B))
+	pdf.Rect(0, 0, pageWidth, 28, "F")
+	pdf.SetTextColor(255, 255, 255)
+	pdf.SetFont("Helvetica", "B", 22)
+	pdf.SetXY(12, 9)
+	pdf.CellFormat(contentWidth, 10, "PARKING APPROVED", "", 1, "C", false, 0, "")
+
+	pdf.SetTextColor(0, 0, 0)
+	pdf.SetXY(12, 38)
+	pdf.SetFont("Helvetica", "B", 14)
+	pdf.MultiCell(contentWidth, 7, translate(entry.PropertyName), "", "L", false)
+	if address := receiptAddress(entry); address != "" {
+		pdf.SetFont("Helvetica", "", 10)
+		pdf.SetTextColor(85, 85, 85)
+		pdf.MultiCell(contentWidth, 5, translate(address), "", "L", false)
+	}
+	pdf.Ln(6)
+
+	for _, line := range receiptLines(entry) {
+		pdf.SetFont("Helvetica", "", 10)
+		pdf.SetTextColor(85, 85, 85)
+		pdf.CellFormat(38, 8, line.label, "", 0, "L", false, 0, "")
+		pdf.SetFont("Helvetica", "B", 12)
+		pdf.SetTextColor(0, 0, 0)
+		pdf.CellFormat(contentWidth-38, 8, translate(line.value), "", 1, "L", false, 0, "")
+	}
+	pdf.Ln(8)
+
+	pdf.SetFont("Helvetica", "", 10)
+	pdf.SetTextColor(85, 85, 85)
+	pdf.CellFormat(contentWidth, 6, "Confirmation Code", "", 1, "C", false, 0, "")
+	pdf.SetFont("Helvetica", "B", 24)
+	pdf.SetTextColor(int(receiptGreen.R), int(receiptGreen.G), int(receiptGreen.B))
+	pdf.CellFormat(contentWidth, 12, entry.ConfirmationCode, "", 1, "C", false, 0, "")
+
+	const qrSize = 55
+	pdf.RegisterImageOptionsReader("qr", gofpdf.ImageOptions{ImageType: "PNG"}, bytes.NewReader(qrPNG))
+	pdf.ImageOptions("qr", (pageWidth-qrSize)/2, pdf.GetY()+4, qrSize, qrSize, false, gofpdf.ImageOptions{ImageType: "PNG"}, 0, "")
+
+	var buffer bytes.Buffer
+	if err := pdf.Output(&buffer); err != nil {
+		return nil, fmt.Errorf("failed to render receipt PDF: %w", err)
+	}
+	return buffer.Bytes(), nil
+}
+
+// ReceiptFiles renders both receipt formats for an approved registration as Discord attachments.
+func ReceiptFiles(entry HistoryEntry) ([]*discordgo.File, error) {
+	image, err := RenderReceiptPNG(entry)
+	if err != nil {
+		return nil, err
+	}
+	document, err := RenderReceiptPDF(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	name := fmt.Sprintf("receipt-%s", entry.ConfirmationCode)
+	return []*discordgo.File{
+		{Name: name + ".png", ContentType: "image/png", Reader: bytes.NewReader(image)},
+		{Name: name + ".pdf", ContentType: "application/pdf", Reader: bytes.NewReader(document)},
+	}, nil
+}
+
+// ReceiptHandler attaches the receipt for an approved registration selected from /history.
+func ReceiptHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+	data := interaction.MessageComponentData()
+	_, entryId, _ := strings.Cut(data.CustomID, ":")
+	if len(data.Values) > 0 {
+		entryId = data.Values[0]
+	}
+
+	entry, ok := getOwnApprovedEntry(session, interaction, entryId)
+	if !ok {
+		return
+	}
+
+	files, err := ReceiptFiles(entry)
+	if err != nil {
+		HandleError(session, interaction, err, "Failed to render receipt")
+		return
+	}
+
+	err = session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Flags: discordgo.MessageFlagsEphemeral,
+			Files: files,
+		},
+	})
+	if err != nil {
+		log.WithField("error", err).Error("Failed to attach receipt")
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRenderReceiptPNGConcurrently(t *testing.T) {
+	entry := HistoryEntry{
+		PropertyName:     "Luxx, The",
+		Plate:            "ABC1234",
+		Make:             "Honda",
+		Model:            "Pilot",
+		Approved:         true,
+		ConfirmationCode: "J63FBM4V",
+		RegisteredAt:     time.Date(2024, 3, 1, 18, 0, 0, 0, time.UTC),
+		ExpiresAt:        time.Date(2024, 3, 2, 18, 0, 0, 0, time.UTC),
+	}
+
+	// Receipts are rendered from several handlers at once, so each must be drawn independently
+	images := make([][]byte, 8)
+	var group sync.WaitGroup
+	for index := range images {
+		group.Add(1)
+		go func(index int) {
+			defer group.Done()
+			image, err := RenderReceiptPNG(entry)
+			if err != nil {
+				t.Errorf("failed to render receipt: %v", err)
+			}
+			images[index] = image
+		}(index)
+	}
+	group.Wait()
+
+	for _, image := range images[1:] {
+		if !bytes.Equal(image, images[0]) {
+			t.Fatal("receipts rendered at the same time differ")
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// redacted replaces personal details in logs, debug dumps and errors.
+const redacted = "[redacted]"
+
+// sensitiveFields are the names of form parameters, JSON properties and log fields holding personal details.
+var sensitiveFields = []string{
+	"guestCode", "code", "vehicleLicensePlate", "vehicleLicensePlateConfirm", "plate", "vehicleApt", "apartment", "apt",
+	"email", "vehicleGuestEmail", "vehicleGuestPhone", "vehicleGuestName", "vehicleResidentName", "vehicleAddress", "PHPSESSID",
+}
+
+var (
+	fieldNames = strings.Join(sensitiveFields, "|")
+	// Matches form bodies and query strings, e.g. 'guestCode=ABCDEFGH', as well as cookies (but not values already redacted)
+	paramPattern = regexp.MustCompile(`(?i)\b(` + fieldNames + `)=([^&;\s"\[\]]+)`)
+	// Matches JSON properties, e.g. '"plate":"ABC1234"'
+	jsonPattern = regexp.MustCompile(`(?i)"(` + fieldNames + `)":\s*"([^"]*)"`)
+	// Matches details shown in R2P's registration results, e.g. '<strong>License Plate:</strong> ABC1234'
+	labelPattern = regexp.MustCompile(`(?i)((?:License Plate|Guest Code|Apartment|Email)\s*:\s*(?:</strong>)?\s*)([^<\s][^<\n]*)`)
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	// Matches maps and structs as formatted by fmt (with %+v), e.g. 'map[vehicleLicensePlate:ABC1234]'
+	formattedPattern = regexp.MustCompile(`(?i)\b(` + fieldNames + `):([^\s\[\]{}]+)`)
+)
+
+// Redact masks guest codes, plates, emails and other personal details within text, unless -unsafe-debug is set.
+func Redact(text string) string {
+	if *unsafeDebugFlag {
+		return text
+	}
+
+	text = paramPattern.ReplaceAllString(text, "$1="+redacted)
+	text = jsonPattern.ReplaceAllString(text, `"$1":"`+redacted+`"`)
+	text = labelPattern.ReplaceAllString(text, "${1}"+redacted)
+	return emailPattern.ReplaceAllString(text, redacted)
+}
+
+// redactValue masks the value of a named field (e.g. a form parameter or cookie) if it holds personal details,
+// otherwise redacting any details within it.
+func redactValue(name string, value string) string {
+	if *unsafeDebugFlag {
+		return value
+	}
+	if value != "" && sensitiveField(name) {
+		return redacted
+	}
+	return Redact(value)
+}
+
+// sensitiveField returns true if a field (by name) holds personal details.
+func sensitiveField(name string) bool {
+	for _, field := range sensitiveFields {
+		if strings.EqualFold(name, field) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactingFormatter redacts personal details from log messages and fields before they're formatted.
+type RedactingFormatter struct {
+	log.Formatter
+}
+
+func (formatter *RedactingFormatter) Format(entry *log.Entry) ([]byte, error) {
+	if *unsafeDebugFlag {
+		return formatter.Formatter.Format(entry)
+	}
+
+	// The entry's fields may be shared with other entries, so a copy is redacted
+	copied := *entry
+	copied.Message = Redact(entry.Message)
+	copied.Data = make(log.Fields, len(entry.Data))
+	for key, value := range entry.Data {
+		switch {
+		case value == nil:
+		case sensitiveField(key):
+			value = redacted
+		default:
+			// Maps, structs and slices (e.g. form values) are formatted so the details within them can be found
+			formatted := fmt.Sprintf("%+v", value)
+			if _, ok := value.(string); !ok {
+				formatted = formattedPattern.ReplaceAllString(formatted, "$1:"+redacted)
+			}
+			value = Redact(formatted)
+		}
+		copied.Data[key] = value
+	}
+
+	return formatter.Formatter.Format(&copied)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/go-redis/redis"
+	"github.com/sirupsen/logrus"
+)
+
+// reminderSnooze is how long the "Snooze" button on a reminder delays it by.
+const reminderSnooze = 30 * time.Minute
+
+var RemindersCommandDefinition = &discordgo.ApplicationCommand{
+	Name:        "reminders",
+	Description: "Choose when to be reminded before a registration expires",
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionInteger,
+			Name:        "before",
+			Description: "How long before expiry to send the reminder",
+			Required:    true,
+			Choices: []*discordgo.ApplicationCommandOptionChoice{
+				{Name: "Never", Value: 0},
+				{Name: "15 minutes", Value: 15},
+				{Name: "30 minutes", Value: 30},
+				{Name: "1 hour", Value: 60},
+				{Name: "2 hours", Value: 120},
+				{Name: "4 hours", Value: 240},
+			},
+		},
+	},
+}
+
+func RemindersCommandHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+	userId, parseErr := strconv.Atoi(GetUser(interaction).ID)
+	if parseErr != nil {
+		HandleError(session, interaction, parseErr, "Error occurred while parsing user id")
+		return
+	}
+
+	minutes := int(interaction.ApplicationCommandData().Options[0].IntValue())
+	SetReminderLead(userId, time.Duration(minutes)*time.Minute)
+
+	content := fmt.Sprintf("You'll be reminded %d minute%s before each registration expires.", minutes, Plural(minutes))
+	if minutes == 0 {
+		content = "You will no longer be reminded before registrations expire."
+	}
+
+	err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: content,
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		logrus.WithField("error", err).Error("Failed to confirm reminder preference")
+	}
+}
+
+// SetReminderLead stores how long before expiry a member wants to be reminded. Zero disables reminders.
+func SetReminderLead(member int, lead time.Duration) {
+	db.Set(fmt.Sprintf("reminder_lead:%d", member), int64(lead/time.Minute), 0)
+}
+
+// GetReminderLead returns how long before expiry a member wants to be reminded.
+// Members that have not chosen are reminded according to REMINDER_LEAD_MINUTES, or an hour if it is unset.
+func GetReminderLead(member int) time.Duration {
+	minutes, err := db.Get(fmt.Sprintf("reminder_lead:%d", member)).Int64()
+	if err == redis.Nil {
+		minutes = 60
+		if value, parseErr := strconv.ParseInt(os.Getenv("REMINDER_LEAD_MINUTES"), 10, 64); parseErr == nil {
+			minutes = value
+		}
+	} else if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "member": member}).Warn("Failed to retrieve reminder preference")
+		return 0
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// ScheduleReminder schedules an expiry reminder for a submission, replacing any already scheduled.
+func ScheduleReminder(submissionId string, at time.Time) {
+	CancelReminder(submissionId)
+	jobId := ScheduleJob("remind", submissionId, at)
+	db.Set(fmt.Sprintf("reminder:%s", submissionId), jobId, time.Until(at)+time.Hour)
+}
+
+// CancelReminder cancels the expiry reminder scheduled for a submission, if any.
+func CancelReminder(submissionId string) {
+	key := fmt.Sprintf("reminder:%s", submissionId)
+	if jobId, err := db.Get(key).Result(); err == nil {
+		CancelJob(jobId)
+		db.Del(key)
+	}
+}
+
+// ScheduleExpiryReminder schedules a reminder ahead of an approved submission's expiry, according to the member's preference.
+func ScheduleExpiryReminder(submissionId string, submission Submission) {
+	lead := GetReminderLead(submission.Member)
+	if lead <= 0 || submission.Expiry.IsZero() {
+		return
+	}
+
+	at := submission.Expiry.Add(-lead)
+	if at.Before(time.Now()) {
+		return
+	}
+	ScheduleReminder(submissionId, at)
+}
+
+// RemindJobHandler sends the member a DM reminding them their registration is about to expire.
+func RemindJobHandler(job *Job) error {
+	submissionId := job.Target
+	db.Del(fmt.Sprintf("reminder:%s", submissionId))
+
+	submission, ok := GetSubmission(submissionId)
+	if !ok || time.Now().After(submission.Expiry) {
+		return nil
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title:       "Registration Expiring",
+		Color:       0xffa500,
+		Description: fmt.Sprintf("Your registration expires <t:%d:R>.", submission.Expiry.Unix()),
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: GetFooterText(),
+		},
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Location", Value: GetLocationName(submission.PropertyId), Inline: true},
+			{Name: "License Plate", Value: strings.ToUpper(submission.Values["vehicleLicensePlate"]), Inline: true},
+		},
+	}
+
+	buttons := []discordgo.MessageComponent{
+		discordgo.Button{
+			Label:    "Snooze 30m",
+			Style:    discordgo.SecondaryButton,
+			CustomID: "reminder-snooze:" + submissionId,
+		},
+		discordgo.Button{
+			Label:    "Stop reminding",
+			Style:    discordgo.DangerButton,
+			CustomID: "reminder-stop:" + submissionId,
+		},
+	}
+	// Registrations made on the member's behalf can't be repeated by them
+	if submission.RegisteredBy == 0 {
+		buttons = append([]discordgo.MessageComponent{discordgo.Button{
+			Label:    "Renew now",
+			Style:    discordgo.SuccessButton,
+			CustomID: "register-again:" + submissionId,
+		}}, buttons...)
+	}
+
+	return SendDirectMessage(session, strconv.Itoa(submission.Member), &discordgo.MessageSend{
+		Embeds:     []*discordgo.MessageEmbed{embed},
+		Components: []discordgo.MessageComponent{discordgo.ActionsRow{Components: buttons}},
+	})
+}
+
+// ReminderSnoozeHandler delays a reminder, unless the registration will have expired by then.
+func ReminderSnoozeHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+	submission, ok := getOwnSubmission(session, interaction, interaction.MessageComponentData().CustomID)
+	if !ok {
+		return
+	}
+	_, submissionId, _ := strings.Cut(interaction.MessageComponentData().CustomID, ":")
+
+	at := time.Now().Add(reminderSnooze)
+	content := fmt.Sprintf("Snoozed, you'll be reminded again <t:%d:R>.", at.Unix())
+	if at.After(submission.Expiry) {
+		content = fmt.Sprintf("This registration expires <t:%d:R>, before the snooze would end.", submission.Expiry.Unix())
+	} else {
+		ScheduleReminder(submissionId, at)
+	}
+
+	respondToReminder(session, interaction, content)
+}
+
+// ReminderStopHandler cancels any further reminders for a registration.
+func ReminderStopHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+	if _, ok := getOwnSubmission(session, interaction, interaction.MessageComponentData().CustomID); !ok {
+		return
+	}
+	_, submissionId, _ := strings.Cut(interaction.MessageComponentData().CustomID, ":")
+
+	CancelReminder(submissionId)
+	respondToReminder(session, interaction, "You won't be reminded about this registration again.")
+}
+
+// respondToReminder replaces a reminder's buttons with a short note of what was done.
+func respondToReminder(session *discordgo.Session, interaction *discordgo.InteractionCreate, content string) {
+	err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Content:    content,
+			Components: []discordgo.MessageComponent{},
+		},
+	})
+	if err != nil {
+		logrus.WithField("error", err).Error("Failed to respond to reminder")
+	}
+}
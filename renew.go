@@ -0,0 +1,323 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+)
+
+// renewalLead is how long before a registration expires that it is renewed.
+const renewalLead = 20 * time.Minute
+
+// renewalDayChoices are the durations offered when enabling auto-renew, where zero renews until cancelled.
+var renewalDayChoices = []int{1, 2, 3, 5, 7, 14, 30, 0}
+
+// StoreRenewal stores (or replaces) a renewal and indexes it under its member.
+func StoreRenewal(renewal Renewal) {
+	storeJSON(fmt.Sprintf("renewal:%s", renewal.ID), renewal, 0)
+	db.SAdd(fmt.Sprintf("renewals:%d", renewal.Submission.Member), renewal.ID)
+	if renewal.SubmissionID != "" {
+		db.Set(fmt.Sprintf("submission-renewal:%s", renewal.SubmissionID), renewal.ID, 0)
+	}
+}
+
+// GetRenewal returns the renewal with the given identifier.
+func GetRenewal(id string) (Renewal, bool) {
+	var renewal Renewal
+	ok := loadJSON(fmt.Sprintf("renewal:%s", id), &renewal)
+	return renewal, ok
+}
+
+// GetSubmissionRenewal returns the renewal of a submission, if it has one.
+func GetSubmissionRenewal(submissionId string) (Renewal, bool) {
+	id, err := db.Get(fmt.Sprintf("submission-renewal:%s", submissionId)).Result()
+	if err != nil {
+		return Renewal{}, false
+	}
+	return GetRenewal(id)
+}
+
+// GetRenewals returns every renewal belonging to a member.
+func GetRenewals(member int) []Renewal {
+	renewals := make([]Renewal, 0)
+	for _, id := range db.SMembers(fmt.Sprintf("renewals:%d", member)).Val() {
+		if renewal, ok := GetRenewal(id); ok {
+			renewals = append(renewals, renewal)
+		}
+	}
+	return renewals
+}
+
+// RemoveRenewal removes a renewal. Any job already scheduled for it will find nothing to do.
+func RemoveRenewal(renewal Renewal) {
+	db.Del(fmt.Sprintf("renewal:%s", renewal.ID))
+	db.SRem(fmt.Sprintf("renewals:%d", renewal.Submission.Member), renewal.ID)
+	if renewal.SubmissionID != "" {
+		db.Del(fmt.Sprintf("submission-renewal:%s", renewal.SubmissionID))
+	}
+}
+
+// scheduleRenewal schedules the next renewal shortly before the current registration expires.
+func scheduleRenewal(renewal Renewal) {
+	ScheduleJob("renew", renewal.ID, renewal.Expiry.Add(-renewalLead))
+}
+
+// AutoRenewCancelButton builds the button that cancels a renewal.
+func AutoRenewCancelButton(renewalId string) discordgo.ActionsRow {
+	return discordgo.ActionsRow{
+		Components: []discordgo.MessageComponent{
+			discordgo.Button{
+				Label:    "Cancel auto-renew",
+				Style:    discordgo.DangerButton,
+				CustomID: "auto-renew-cancel:" + renewalId,
+			},
+		},
+	}
+}
+
+// AutoRenewHandler asks the user how long an approved registration should be renewed for.
+func AutoRenewHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+	submission, ok := getRepeatableSubmission(session, interaction, interaction.MessageComponentData().CustomID)
+	if !ok {
+		return
+	} else if submission.Expiry.IsZero() {
+		HandleError(session, interaction, nil, ":x: Only approved registrations can be renewed.")
+		return
+	}
+	_, submissionId, _ := strings.Cut(interaction.MessageComponentData().CustomID, ":")
+
+	options := make([]discordgo.SelectMenuOption, len(renewalDayChoices))
+	for i, days := range renewalDayChoices {
+		label := fmt.Sprintf("For %d day%s", days, Plural(days))
+		if days == 0 {
+			label = "Until cancelled"
+		}
+		options[i] = discordgo.SelectMenuOption{Label: label, Value: strconv.Itoa(days)}
+	}
+
+	err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: "How long should this registration be renewed for?",
+			Flags:   discordgo.MessageFlagsEphemeral,
+			Components: []discordgo.MessageComponent{
+				discordgo.ActionsRow{
+					Components: []discordgo.MessageComponent{
+						discordgo.SelectMenu{
+							MenuType:    discordgo.StringSelectMenu,
+							CustomID:    "auto-renew-days:" + submissionId,
+							Placeholder: "Duration",
+							Options:     options,
+						},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		log.WithField("error", err).Error("Failed to present auto-renew durations")
+	}
+}
+
+// AutoRenewDaysHandler enables auto-renew for a registration once a duration has been chosen.
+func AutoRenewDaysHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+	data := interaction.MessageComponentData()
+	submission, ok := getRepeatableSubmission(session, interaction, data.CustomID)
+	if !ok {
+		return
+	}
+
+	days, parseErr := strconv.Atoi(data.Values[0])
+	if parseErr != nil {
+		HandleError(session, interaction, parseErr, "Error occurred while parsing renewal duration")
+		return
+	}
+
+	// Choosing again (or from another message) changes the duration of the existing renewal, which is already scheduled
+	_, submissionId, _ := strings.Cut(data.CustomID, ":")
+	renewal, exists := GetSubmissionRenewal(submissionId)
+	if !exists {
+		renewal = Renewal{
+			ID:           NewIdentifier(),
+			SubmissionID: submissionId,
+			Submission:   submission,
+			Expiry:       submission.Expiry,
+		}
+	}
+	renewal.Until = time.Time{}
+	if days > 0 {
+		renewal.Until = time.Now().Add(time.Duration(days) * 24 * time.Hour)
+	}
+
+	StoreRenewal(renewal)
+	if !exists {
+		scheduleRenewal(renewal)
+	}
+
+	// Renewals make reminders redundant
+	CancelReminder(submissionId)
+
+	description := "until cancelled"
+	if days > 0 {
+		description = fmt.Sprintf("for %d day%s", days, Plural(days))
+	}
+
+	content := fmt.Sprintf("Auto-renew enabled %s. The next renewal will happen <t:%d:R>, and you'll be notified by DM each time.",
+		description, renewal.Expiry.Add(-renewalLead).Unix())
+	err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Content:    content,
+			Components: []discordgo.MessageComponent{AutoRenewCancelButton(renewal.ID)},
+		},
+	})
+	if err != nil {
+		log.WithField("error", err).Error("Failed to confirm auto-renew")
+	}
+}
+
+// AutoRenewCancelHandler stops a renewal from being repeated again.
+func AutoRenewCancelHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+	_, renewalId, _ := strings.Cut(interaction.MessageComponentData().CustomID, ":")
+
+	renewal, ok := GetRenewal(renewalId)
+	if !ok {
+		HandleError(session, interaction, nil, ":x: This auto-renewal has already ended.")
+		return
+	} else if strconv.Itoa(renewal.Submission.Member) != GetUser(interaction).ID {
+		HandleError(session, interaction, nil, ":x: This auto-renewal belongs to someone else.")
+		return
+	}
+
+	RemoveRenewal(renewal)
+
+	err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: fmt.Sprintf("Auto-renew cancelled. Your current registration remains valid until <t:%d:f>.", renewal.Expiry.Unix()),
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		log.WithField("error", err).Error("Failed to confirm auto-renew cancellation")
+	}
+}
+
+// RenewJobHandler registers a renewal's vehicle again and schedules the next renewal.
+// Errors returned are retried by the scheduler; outcomes that retrying cannot fix end the renewal instead.
+func RenewJobHandler(job *Job) error {
+	renewal, ok := GetRenewal(job.Target)
+	if !ok {
+		// The renewal was cancelled
+		return nil
+	}
+	submission := renewal.Submission
+	userId := strconv.Itoa(submission.Member)
+
+	if !renewal.Until.IsZero() && time.Now().After(renewal.Until) {
+		RemoveRenewal(renewal)
+		notifyRenewal(userId, fmt.Sprintf("Auto-renew has finished after %d renewal%s. Your last registration expires <t:%d:R>.",
+			renewal.Count, Plural(renewal.Count), renewal.Expiry.Unix()), nil, nil, nil)
+		return nil
+	}
+
+	capture := Capture{Interaction: job.ID, Member: submission.Member}
+	code, err := SubmissionCode(submission)
+	if errors.Is(err, errSharedCodeUnavailable) {
+		RemoveRenewal(renewal)
+		notifyRenewal(userId, ":x: Auto-renew has stopped, as the guest code shared with you is no longer available.", nil, nil, nil)
+		return nil
+	} else if err != nil {
+		return failRenewal(job, renewal, err)
+	}
+
+	form := FetchForm(capture, submission.PropertyId, code)
+	if form.requireGuestCode {
+		RemoveRenewal(renewal)
+		notifyRenewal(userId, ":x: Auto-renew has stopped, as the guest code used is no longer valid.", nil, nil, nil)
+		return nil
+	} else if form.err != nil {
+		return failRenewal(job, renewal, form.err)
+	}
+
+	context, err := NewRegisterContext(form, submission.PropertyId, code)
+	if err != nil {
+		return failRenewal(job, renewal, err)
+	}
+
+	result, err := RegisterVehicle(capture, submission.Values, context.propertyId, context.residentId, context.hiddenKeys)
+	if errors.Is(err, errUpstreamUnavailable) {
+		return failRenewal(job, renewal, err)
+	} else if err != nil {
+		// Register2Park may have registered the vehicle regardless, so retrying could register it twice
+		log.WithFields(log.Fields{"error": err, "renewal": renewal.ID}).Error("Renewal failed after being sent")
+		RemoveRenewal(renewal)
+		notifyRenewal(userId, fmt.Sprintf(":x: Auto-renew has stopped, as Register2Park didn't confirm whether the vehicle was registered. "+
+			"Your registration expires <t:%d:R>, so please check with the property and register manually if needed.", renewal.Expiry.Unix()), nil, nil, nil)
+		return nil
+	}
+	if submission.SharedBy != "" {
+		RecordSharedCodeUse(submission.SharedBy, submission.PropertyId, submission.Member, "renew")
+	}
+
+	entry := RecordRegistration(NewIdentifier(), submission.Member, 0, submission.PropertyId, submission.Values, result)
+
+	embed := RegistrationResultEmbed(result, submission.PropertyId, submission.Values)
+	if !result.success {
+		RemoveRenewal(renewal)
+		notifyRenewal(userId, ":x: Auto-renew has stopped, as the registration was denied.", embed, nil, nil)
+		return nil
+	}
+
+	renewal.Expiry = result.expiry()
+	renewal.Count++
+	StoreRenewal(renewal)
+	scheduleRenewal(renewal)
+
+	buttons := AutoRenewCancelButton(renewal.ID)
+	buttons.Components = append(buttons.Components, discordgo.Button{
+		Label:    "Add to calendar",
+		Style:    discordgo.SecondaryButton,
+		CustomID: "calendar:" + entry.ID,
+	})
+	files, err := ReceiptFiles(entry)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err, "renewal": renewal.ID}).Warn("Unable to render renewal receipt")
+	}
+	notifyRenewal(userId, fmt.Sprintf("Your registration has been renewed automatically (#%d). It now expires <t:%d:R>.",
+		renewal.Count, renewal.Expiry.Unix()), embed, &buttons, files)
+	return nil
+}
+
+// failRenewal reports a failed renewal attempt. On the final attempt the renewal is ended and the user notified.
+func failRenewal(job *Job, renewal Renewal, err error) error {
+	// Retrying is pointless once the registration has lapsed
+	job.Deadline = renewal.Expiry
+	if job.FinalAttempt() {
+		RemoveRenewal(renewal)
+		notifyRenewal(strconv.Itoa(renewal.Submission.Member), fmt.Sprintf(
+			":x: Auto-renew has stopped after failing %d times. Your registration expires <t:%d:R>, so please register manually.",
+			maxJobAttempts, renewal.Expiry.Unix()), nil, nil, nil)
+	}
+	return fmt.Errorf("failed to renew registration: %w", err)
+}
+
+// notifyRenewal sends a renewal update to a user by DM, optionally with a result embed, buttons and attachments.
+func notifyRenewal(userId string, content string, embed *discordgo.MessageEmbed, buttons *discordgo.ActionsRow, files []*discordgo.File) {
+	message := &discordgo.MessageSend{Content: content, Files: files}
+	if embed != nil {
+		message.Embeds = []*discordgo.MessageEmbed{embed}
+	}
+	if buttons != nil {
+		message.Components = []discordgo.MessageComponent{*buttons}
+	}
+
+	if err := SendDirectMessage(session, userId, message); err != nil {
+		log.WithFields(log.Fields{"error": err, "user": userId}).Warn("Failed to notify user of renewal")
+	}
+}
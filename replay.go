@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// replayControlParams are sent with every registration, rather than being values entered into the form.
+var replayControlParams = []string{"propertyIdSelected", "propertySource", "residentProfileId"}
+
+// ReplayTransport serves responses recorded in HAR archives instead of contacting Register2Park.
+// Requests are matched by method, path, query and form body; redacted values in the recording match any value.
+// Matching entries are served in the order they were recorded, repeating the last once all have been served.
+type ReplayTransport struct {
+	lock      sync.Mutex
+	entries   []HAREntry
+	served    []bool
+	unmatched []string
+}
+
+// NewReplayTransport replays the entries of the given archives.
+func NewReplayTransport(archives ...HAR) *ReplayTransport {
+	transport := &ReplayTransport{}
+	for _, archive := range archives {
+		transport.entries = append(transport.entries, archive.Log.Entries...)
+	}
+	transport.served = make([]bool, len(transport.entries))
+	return transport
+}
+
+func (transport *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := peekRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	form := replayForm(req.Header.Get("Content-Type"), string(body))
+
+	transport.lock.Lock()
+	defer transport.lock.Unlock()
+
+	match := -1
+	for index, entry := range transport.entries {
+		if !replayMatches(entry, req, form) {
+			continue
+		}
+		match = index
+		if !transport.served[index] {
+			break
+		}
+	}
+
+	if match == -1 {
+		description := Redact(fmt.Sprintf("%s %s %s", req.Method, req.URL.RequestURI(), body))
+		transport.unmatched = append(transport.unmatched, description)
+		log.WithField("request", description).Warn("No recorded response matches request")
+
+		return &http.Response{
+			Status:     "404 Not Recorded",
+			StatusCode: http.StatusNotFound,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     make(http.Header),
+			Body:       http.NoBody,
+			Request:    req,
+		}, nil
+	}
+
+	transport.served[match] = true
+	return replayResponse(req, transport.entries[match].Response)
+}
+
+// Unmatched describes the requests no recorded entry matched, in the order they were made.
+func (transport *ReplayTransport) Unmatched() []string {
+	transport.lock.Lock()
+	defer transport.lock.Unlock()
+	return slices.Clone(transport.unmatched)
+}
+
+// replayMatches returns true if a recorded entry was made for the same request.
+func replayMatches(entry HAREntry, req *http.Request, form url.Values) bool {
+	recorded, err := url.Parse(entry.Request.URL)
+	if err != nil || entry.Request.Method != req.Method || recorded.Path != req.URL.Path {
+		return false
+	}
+	if !replayValuesMatch(recorded.Query(), req.URL.Query()) {
+		return false
+	}
+
+	var recordedForm url.Values
+	if postData := entry.Request.PostData; postData != nil {
+		recordedForm = replayForm(postData.MimeType, postData.Text)
+	}
+	return replayValuesMatch(recordedForm, form)
+}
+
+// replayForm parses a form body, so it can be compared regardless of parameter order and encoding.
+// Bodies of other types are compared as they are.
+func replayForm(contentType string, body string) url.Values {
+	if body == "" {
+		return url.Values{}
+	}
+	if mimeType, _, _ := mime.ParseMediaType(contentType); mimeType != "application/x-www-form-urlencoded" {
+		return url.Values{"": {body}}
+	}
+
+	form, err := url.ParseQuery(body)
+	if err != nil {
+		return url.Values{"": {body}}
+	}
+	return form
+}
+
+// replayValuesMatch returns true if two sets of parameters have the same names and values.
+// Values redacted from the recording match any value.
+func replayValuesMatch(recorded url.Values, actual url.Values) bool {
+	if len(recorded) != len(actual) {
+		return false
+	}
+	for name, values := range recorded {
+		actualValues, ok := actual[name]
+		if !ok || len(values) != len(actualValues) {
+			return false
+		}
+		for index, value := range values {
+			if value != actualValues[index] && value != redacted {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// replayResponse rebuilds a recorded response.
+func replayResponse(req *http.Request, recorded HARResponse) (*http.Response, error) {
+	body := []byte(recorded.Content.Text)
+	if recorded.Content.Encoding == "base64" {
+		decoded, err := base64.StdEncoding.DecodeString(recorded.Content.Text)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode recorded response: %w", err)
+		}
+		body = decoded
+	}
+
+	header := make(http.Header)
+	for _, value := range recorded.Headers {
+		// Recorded bodies are already decoded, so headers describing their encoding no longer apply
+		switch http.CanonicalHeaderKey(value.Name) {
+		case "Content-Encoding", "Content-Length", "Transfer-Encoding":
+			continue
+		}
+		header.Add(value.Name, value.Value)
+	}
+
+	status := strconv.Itoa(recorded.Status)
+	if recorded.StatusText != "" {
+		status += " " + recorded.StatusText
+	}
+
+	return &http.Response{
+		Status:        status,
+		StatusCode:    recorded.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// recordedParams returns the parameters of the last recorded request to a path (query and form combined), if any.
+func recordedParams(archives []HAR, path string) (url.Values, bool) {
+	var params url.Values
+	for _, archive := range archives {
+		for _, entry := range archive.Log.Entries {
+			recorded, err := url.Parse(entry.Request.URL)
+			if err != nil || recorded.Path != path {
+				continue
+			}
+
+			params = recorded.Query()
+			if postData := entry.Request.PostData; postData != nil {
+				for name, values := range replayForm(postData.MimeType, postData.Text) {
+					params[name] = values
+				}
+			}
+		}
+	}
+	return params, params != nil
+}
+
+// ReplayOptions override the details of a replayed registration, which are otherwise taken from the recording.
+type ReplayOptions struct {
+	Location uint   // The property to register at
+	Code     string // The guest code to register with
+	Email    string // The email to send a confirmation to
+}
+
+// ReplayOutcome describes what happened when recorded sessions were replayed.
+type ReplayOutcome struct {
+	Form      GetFormResult       // The registration form retrieved, if a registration was replayed
+	Result    *RegistrationResult // The registration's result, if one was made
+	Confirmed bool                // Whether an email confirmation was sent
+	Unmatched []string            // Requests no recorded entry matched, in the order they were made
+}
+
+// Replay runs a registration (and its email confirmation) against recorded sessions instead of Register2Park.
+// Recordings holding only an email confirmation replay just the confirmation.
+func Replay(archives []HAR, options ReplayOptions) (outcome ReplayOutcome, err error) {
+	transport := NewReplayTransport(archives...)
+	previous := client.Transport
+	client.Transport = transport
+	defer func() {
+		client.Transport = previous
+		outcome.Unmatched = transport.Unmatched()
+	}()
+
+	registration, registered := recordedParams(archives, "/register-vehicle-vip-process")
+	confirmation, confirmed := recordedParams(archives, "/register-vehicle-confirmation-process")
+	if options.Location == 0 {
+		recorded := registration.Get("propertyIdSelected")
+		if !registered {
+			recorded = confirmation.Get("propertyId")
+		}
+		location, _ := strconv.ParseUint(recorded, 10, 32)
+		options.Location = uint(location)
+	}
+	if options.Code == "" {
+		if vipForm, ok := recordedParams(archives, "/register-get-vip-vehicle-form"); ok {
+			options.Code = vipForm.Get("guestCode")
+		}
+	}
+	if options.Email == "" {
+		options.Email = confirmation.Get("email")
+	}
+	if options.Location == 0 {
+		return outcome, errors.New("no registration or confirmation was recorded, so a location must be given")
+	}
+
+	vehicleId := confirmation.Get("vehicleId")
+	if registered || !confirmed {
+		outcome.Form = FetchForm(Capture{}, options.Location, options.Code)
+		if outcome.Form.err != nil {
+			return outcome, fmt.Errorf("failed to retrieve the registration form: %w", outcome.Form.err)
+		} else if outcome.Form.requireGuestCode {
+			return outcome, errors.New("a guest code is required to retrieve the registration form")
+		}
+
+		context, err := NewRegisterContext(outcome.Form, options.Location, options.Code)
+		if err != nil {
+			return outcome, fmt.Errorf("failed to parse the registration form: %w", err)
+		}
+
+		values := make(map[string]string)
+		for name := range registration {
+			if !slices.Contains(replayControlParams, name) {
+				values[name] = registration.Get(name)
+			}
+		}
+
+		outcome.Result, err = RegisterVehicle(Capture{}, values, context.propertyId, context.residentId, context.hiddenKeys)
+		if err != nil {
+			return outcome, fmt.Errorf("failed to register vehicle: %w", err)
+		}
+		if outcome.Result.vehicleId != "" {
+			vehicleId = outcome.Result.vehicleId
+		}
+	}
+
+	if confirmed || options.Email != "" {
+		outcome.Confirmed, err = RegisterEmailConfirmation(Capture{}, options.Email, vehicleId, strconv.Itoa(int(options.Location)))
+		if err != nil {
+			return outcome, fmt.Errorf("failed to send email confirmation: %w", err)
+		}
+	}
+	return outcome, nil
+}
+
+// ReplayCommand runs a registration offline against recorded sessions, reporting the outcome and any request
+// that wasn't recorded. The property, guest code, vehicle and email are taken from the recording unless given.
+func ReplayCommand(args []string) {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	location := flags.Uint("location", 0, "The property to register at (defaults to the recorded one)")
+	code := flags.String("code", "", "The guest code to register with (defaults to the recorded one)")
+	email := flags.String("email", "", "The email to send a confirmation to (defaults to the recorded one)")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		log.Fatal("Usage: replay [-location id] [-code code] [-email address] <file.har>...")
+	}
+
+	archives := make([]HAR, 0, flags.NArg())
+	for _, path := range flags.Args() {
+		archive, err := ReadHARFile(path)
+		if err != nil {
+			log.WithFields(log.Fields{"error": err, "path": path}).Fatal("Failed to read recording")
+		}
+		archives = append(archives, archive)
+	}
+
+	failed := false
+	outcome, err := Replay(archives, ReplayOptions{Location: *location, Code: *code, Email: *email})
+	if outcome.Result != nil {
+		log.WithFields(log.Fields{
+			"property":     outcome.Form.propertyName,
+			"approved":     outcome.Result.success,
+			"confirmation": outcome.Result.confirmationCode,
+			"vehicleId":    outcome.Result.vehicleId,
+			"timestamp":    outcome.Result.timestamp,
+			"expires":      outcome.Result.expiry(),
+		}).Info("Registered vehicle")
+	}
+	if outcome.Confirmed {
+		log.Info("Sent email confirmation")
+	}
+	if err != nil {
+		log.WithField("error", err).Error("Failed to replay registration")
+		failed = true
+	}
+
+	if len(outcome.Unmatched) > 0 {
+		fmt.Fprintf(os.Stderr, "%d request%s did not match the recording:\n", len(outcome.Unmatched), Plural(len(outcome.Unmatched)))
+		for _, description := range outcome.Unmatched {
+			fmt.Fprintf(os.Stderr, "  %s\n", strings.TrimSpace(description))
+		}
+		failed = true
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readSamples reads recordings from the samples directory.
+func readSamples(t *testing.T, names ...string) []HAR {
+	t.Helper()
+
+	archives := make([]HAR, 0, len(names))
+	for _, name := range names {
+		archive, err := ReadHARFile(filepath.Join("samples", name))
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", name, err)
+		}
+		archives = append(archives, archive)
+	}
+	return archives
+}
+
+func TestReplayApproved(t *testing.T) {
+	outcome, err := Replay(readSamples(t, "full.har"), ReplayOptions{})
+	if err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+
+	if outcome.Form.propertyName != "Luxx, The" {
+		t.Errorf("property = %q, want %q", outcome.Form.propertyName, "Luxx, The")
+	}
+	if outcome.Result == nil || !outcome.Result.success {
+		t.Fatalf("result = %+v, want approved", outcome.Result)
+	}
+	if outcome.Result.confirmationCode != "J63FBM4V" {
+		t.Errorf("confirmation = %q, want %q", outcome.Result.confirmationCode, "J63FBM4V")
+	}
+	if outcome.Result.vehicleId != "64647" {
+		t.Errorf("vehicle = %q, want %q", outcome.Result.vehicleId, "64647")
+	}
+	if outcome.Confirmed {
+		t.Error("confirmation was sent, but none was recorded")
+	}
+	if len(outcome.Unmatched) > 0 {
+		t.Errorf("unmatched requests: %v", outcome.Unmatched)
+	}
+}
+
+func TestReplayDenied(t *testing.T) {
+	outcome, err := Replay(readSamples(t, "denied.har"), ReplayOptions{})
+	if err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+
+	if outcome.Result == nil || outcome.Result.success {
+		t.Fatalf("result = %+v, want denied", outcome.Result)
+	}
+	if outcome.Result.confirmationCode != "" {
+		t.Errorf("confirmation = %q, want none", outcome.Result.confirmationCode)
+	}
+	if len(outcome.Unmatched) > 0 {
+		t.Errorf("unmatched requests: %v", outcome.Unmatched)
+	}
+}
+
+func TestReplayConfirmationOnly(t *testing.T) {
+	outcome, err := Replay(readSamples(t, "partial-success.har"), ReplayOptions{})
+	if err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+
+	if outcome.Result != nil {
+		t.Errorf("result = %+v, but no registration was recorded", outcome.Result)
+	}
+	if !outcome.Confirmed {
+		t.Error("confirmation wasn't sent")
+	}
+	if len(outcome.Unmatched) > 0 {
+		t.Errorf("unmatched requests: %v", outcome.Unmatched)
+	}
+}
+
+func TestReplayUnmatched(t *testing.T) {
+	tests := []struct {
+		name      string
+		samples   []string
+		options   ReplayOptions
+		unmatched string // A path the unmatched request was made to
+	}{
+		// The confirmation was recorded for another vehicle than the one registered
+		{"other vehicle", []string{"full.har", "partial-success.har"}, ReplayOptions{}, "/register-vehicle-confirmation-process"},
+		{"other guest code", []string{"full.har"}, ReplayOptions{Code: "ZZZZZZZZ"}, "/register-get-vip-vehicle-form"},
+		{"other location", []string{"denied.har"}, ReplayOptions{Location: 1}, "/register-get-vip-vehicle-form"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			outcome, err := Replay(readSamples(t, test.samples...), test.options)
+			if err == nil {
+				t.Error("replay succeeded, want an error")
+			}
+			if len(outcome.Unmatched) != 1 {
+				t.Fatalf("unmatched = %v, want one request", outcome.Unmatched)
+			}
+			if !strings.Contains(outcome.Unmatched[0], test.unmatched) {
+				t.Errorf("unmatched = %q, want a request to %s", outcome.Unmatched[0], test.unmatched)
+			}
+		})
+	}
+}
+
+func TestReplayRedactedValuesMatchAny(t *testing.T) {
+	archives := readSamples(t, "full.har")
+
+	// Recordings made by the bot have their personal details redacted
+	for index, entry := range archives[0].Log.Entries {
+		if entry.Request.PostData != nil {
+			archives[0].Log.Entries[index].Request.PostData.Text = Redact(entry.Request.PostData.Text)
+		}
+	}
+
+	outcome, err := Replay(archives, ReplayOptions{Code: "ABCDEFGH"})
+	if err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	if outcome.Result == nil || !outcome.Result.success {
+		t.Errorf("result = %+v, want approved", outcome.Result)
+	}
+	if len(outcome.Unmatched) > 0 {
+		t.Errorf("unmatched requests: %v", outcome.Unmatched)
+	}
+}
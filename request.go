@@ -0,0 +1,410 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/samber/lo"
+	"github.com/sirupsen/logrus"
+)
+
+// parkingRequestTimeout is how long a resident has to respond to a guest's parking request.
+const parkingRequestTimeout = time.Hour
+
+// StoreParkingRequest stores a pending parking request until it expires.
+func StoreParkingRequest(request ParkingRequest) {
+	storeJSON(fmt.Sprintf("parking_request:%s", request.ID), request, time.Until(request.Expires)+time.Minute)
+}
+
+// claimParkingRequest removes a pending parking request so it can be decided on, returning false if it was already decided or expired.
+// Removing the request claims it, so a request is never approved (or denied) twice.
+func claimParkingRequest(id string) (ParkingRequest, bool) {
+	key := fmt.Sprintf("parking_request:%s", id)
+
+	var request ParkingRequest
+	if !loadJSON(key, &request) || db.Del(key).Val() == 0 {
+		return request, false
+	}
+	return request, true
+}
+
+var RequestParkingCommandDefinition = &discordgo.ApplicationCommand{
+	Name:        "request-parking",
+	Description: "Ask a resident to register your vehicle at their complex",
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionUser,
+			Name:        "resident",
+			Description: "The resident to ask",
+			Required:    true,
+		},
+		{
+			Type:         discordgo.ApplicationCommandOptionString,
+			Name:         "location",
+			Description:  "The complex to park at",
+			Required:     true,
+			Autocomplete: true,
+		},
+	},
+}
+
+// RequestParkingCommandHandler presents the guest with the vehicle fields of the resident's registration form.
+func RequestParkingCommandHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+	data := interaction.ApplicationCommandData()
+	options := lo.SliceToMap(data.Options, func(option *discordgo.ApplicationCommandInteractionDataOption) (string, *discordgo.ApplicationCommandInteractionDataOption) {
+		return option.Name, option
+	})
+
+	switch interaction.Type {
+
+	case discordgo.InteractionApplicationCommand:
+		locationId, _ := strconv.Atoi(options["location"].StringValue())
+		if !LocationExists(int64(locationId)) {
+			HandleError(session, interaction, nil, "The location provided does not exist.")
+			return
+		}
+
+		resident := options["resident"].UserValue(nil)
+		residentId, parseErr := strconv.Atoi(resident.ID)
+		if parseErr != nil {
+			HandleError(session, interaction, parseErr, "Error occurred while parsing resident id")
+			return
+		} else if resident.ID == GetUser(interaction).ID {
+			HandleError(session, interaction, nil, ":x: You can't request parking from yourself, use `/register` instead.")
+			return
+		}
+
+		// The form is retrieved with the resident's code up front, so guests aren't asked for details that can't be used
+		code, _ := GetCode(int64(locationId), residentId)
+		form := FetchForm(NewCapture(interaction), uint(locationId), code)
+		if form.requireGuestCode {
+			HandleError(session, interaction, nil, fmt.Sprintf(":x: <@%s> doesn't have a valid guest code stored for this location.", resident.ID))
+			return
+		} else if form.err != nil {
+			HandleError(session, interaction, form.err, ":x: Failed to retrieve the registration form.")
+			return
+		}
+
+		// The resident provides everything besides the vehicle itself
+		form.fields = lo.Filter(form.fields, func(field Field, _ int) bool {
+			return slices.Contains(vehicleFields, field.id)
+		})
+
+		err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseModal,
+			Data: &discordgo.InteractionResponseData{
+				CustomID:   fmt.Sprintf("request-parking:%d:%d", residentId, locationId),
+				Title:      "Parking Request",
+				Components: FormToModalComponents(form, nil),
+			},
+		})
+		if err != nil {
+			logrus.WithField("error", err).Error("Failed to present parking request form")
+		}
+
+	case discordgo.InteractionApplicationCommandAutocomplete:
+		var choices []*discordgo.ApplicationCommandOptionChoice
+		if option, ok := options["location"]; ok && option.Focused {
+			choices = LocationChoices(interaction, option.StringValue())
+		}
+
+		err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+			Data: &discordgo.InteractionResponseData{
+				Choices: choices,
+			},
+		})
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// RequestParkingModalHandler sends the guest's request to the resident for approval.
+// The modal's custom ID identifies the resident and location ('request-parking:resident:location').
+func RequestParkingModalHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+	data := interaction.ModalSubmitData()
+	parts := strings.Split(data.CustomID, ":")
+	if len(parts) != 3 {
+		HandleError(session, interaction, nil, "Failed to parse parking request")
+		return
+	}
+	residentId, residentErr := strconv.Atoi(parts[1])
+	locationId, locationErr := strconv.ParseUint(parts[2], 10, 64)
+	guestId, guestErr := strconv.Atoi(GetUser(interaction).ID)
+	if residentErr != nil || locationErr != nil || guestErr != nil {
+		HandleError(session, interaction, nil, "Failed to parse parking request")
+		return
+	}
+
+	request := ParkingRequest{
+		ID:         NewIdentifier(),
+		Guest:      guestId,
+		Resident:   residentId,
+		PropertyId: uint(locationId),
+		Values:     map[string]string{},
+		Expires:    time.Now().Add(parkingRequestTimeout),
+	}
+	for _, row := range data.Components {
+		input := row.(*discordgo.ActionsRow).Components[0].(*discordgo.TextInput)
+		request.Values[input.CustomID] = input.Value
+	}
+
+	channel, err := session.UserChannelCreate(parts[1])
+	if err != nil {
+		HandleError(session, interaction, err, ":x: Failed to message the resident. They may not accept direct messages.")
+		return
+	}
+
+	embed := ParkingRequestEmbed(request)
+	embed.Description = fmt.Sprintf("<@%d> is asking you to register their vehicle with your guest code. This request expires <t:%d:R>.",
+		request.Guest, request.Expires.Unix())
+	message, err := session.ChannelMessageSendComplex(channel.ID, &discordgo.MessageSend{
+		Embeds: []*discordgo.MessageEmbed{embed},
+		Components: []discordgo.MessageComponent{
+			discordgo.ActionsRow{
+				Components: []discordgo.MessageComponent{
+					discordgo.Button{
+						Label:    "Approve",
+						Style:    discordgo.SuccessButton,
+						CustomID: "request-approve:" + request.ID,
+					},
+					discordgo.Button{
+						Label:    "Deny",
+						Style:    discordgo.DangerButton,
+						CustomID: "request-deny:" + request.ID,
+					},
+				},
+			},
+		},
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	})
+	if err != nil {
+		HandleError(session, interaction, err, ":x: Failed to message the resident. They may not accept direct messages.")
+		return
+	}
+
+	request.ChannelId = channel.ID
+	request.MessageId = message.ID
+	StoreParkingRequest(request)
+	ScheduleJob("request-expire", request.ID, request.Expires)
+
+	err = session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: fmt.Sprintf("Your request has been sent to <@%d>. You'll be messaged once they respond, or when it expires <t:%d:R>.",
+				request.Resident, request.Expires.Unix()),
+			Flags:           discordgo.MessageFlagsEphemeral,
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		},
+	})
+	if err != nil {
+		logrus.WithField("error", err).Error("Failed to confirm parking request")
+	}
+}
+
+// ParkingRequestEmbed describes the vehicle and location of a parking request.
+func ParkingRequestEmbed(request ParkingRequest) *discordgo.MessageEmbed {
+	return &discordgo.MessageEmbed{
+		Title: "Parking Request",
+		Color: 0xffa500,
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: GetFooterText(),
+		},
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Location", Value: GetLocationName(request.PropertyId), Inline: true},
+			{Name: "License Plate", Value: strings.ToUpper(request.Values["vehicleLicensePlate"]), Inline: true},
+			{Name: "Vehicle", Value: strings.TrimSpace(request.Values["vehicleMake"] + " " + request.Values["vehicleModel"]), Inline: true},
+		},
+	}
+}
+
+// RequestApproveHandler approves a parking request from the resident's DM, registering the guest's vehicle.
+// If the form asks for an apartment number and the resident's isn't known, they're asked for it first.
+// The same handler receives the apartment modal, sharing the 'request-approve:id' custom ID.
+func RequestApproveHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+	var customId, apartment string
+	if interaction.Type == discordgo.InteractionModalSubmit {
+		data := interaction.ModalSubmitData()
+		customId = data.CustomID
+		apartment = strings.TrimSpace(data.Components[0].(*discordgo.ActionsRow).Components[0].(*discordgo.TextInput).Value)
+	} else {
+		customId = interaction.MessageComponentData().CustomID
+	}
+	_, requestId, _ := strings.Cut(customId, ":")
+
+	var request ParkingRequest
+	if !loadJSON(fmt.Sprintf("parking_request:%s", requestId), &request) {
+		respondToParkingRequest(session, interaction, "This request has already been handled or has expired.", nil)
+		return
+	}
+
+	code, _ := GetCode(int64(request.PropertyId), request.Resident)
+	form := FetchForm(NewCapture(interaction), request.PropertyId, code)
+	if form.requireGuestCode {
+		HandleError(session, interaction, nil, ":x: Your guest code for this location is no longer valid. Update it with `/code set`, then approve again.")
+		return
+	} else if form.err != nil {
+		HandleError(session, interaction, form.err, ":x: Failed to retrieve the registration form.")
+		return
+	}
+
+	needsApartment := slices.ContainsFunc(form.fields, func(field Field) bool { return field.id == "vehicleApt" })
+	if needsApartment && apartment == "" {
+		var ok bool
+		if apartment, ok = GetApartment(request.PropertyId, request.Resident); !ok {
+			err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+				Type: discordgo.InteractionResponseModal,
+				Data: &discordgo.InteractionResponseData{
+					CustomID: "request-approve:" + request.ID,
+					Title:    "Approve Parking Request",
+					Components: FormToModalComponents(GetFormResult{
+						fields: []Field{{text: "Apartment Number", id: "vehicleApt"}},
+					}, nil),
+				},
+			})
+			if err != nil {
+				logrus.WithField("error", err).Error("Failed to ask for apartment number")
+			}
+			return
+		}
+	}
+
+	request, ok := claimParkingRequest(request.ID)
+	if !ok {
+		respondToParkingRequest(session, interaction, "This request has already been handled or has expired.", nil)
+		return
+	}
+	if needsApartment {
+		request.Values["vehicleApt"] = apartment
+		StoreApartment(request.PropertyId, request.Resident, apartment)
+	}
+
+	// Registering can take a moment, so the buttons are removed while it happens
+	respondToParkingRequest(session, interaction, "Approved, registering the vehicle...", ParkingRequestEmbed(request))
+
+	context, err := NewRegisterContext(form, request.PropertyId, code)
+	if err != nil {
+		HandleError(session, interaction, err, "Error occurred while parsing resident profile identifier")
+		return
+	}
+
+	formParams := make(map[string]string, len(form.fields))
+	for _, field := range form.fields {
+		if value, ok := request.Values[field.id]; ok {
+			formParams[field.id] = value
+		}
+	}
+
+	result, err := RegisterVehicle(NewCapture(interaction), formParams, context.propertyId, context.residentId, context.hiddenKeys)
+	if err != nil {
+		HandleError(session, interaction, err, "Failed to register vehicle")
+		notifyParkingRequest(request.Guest, fmt.Sprintf("<@%d> approved your parking request, but registering your vehicle failed. Please try again.", request.Resident), nil, nil)
+		return
+	}
+
+	entry := RecordRegistration(request.ID, request.Guest, request.Resident, request.PropertyId, formParams, result)
+	embed := RegistrationResultEmbed(result, request.PropertyId, formParams)
+
+	content := "Approved, and the vehicle has been registered."
+	if !result.success {
+		content = "Approved, but Register2Park denied the registration."
+	}
+	_, err = session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{
+		Content:    &content,
+		Embeds:     &[]*discordgo.MessageEmbed{embed},
+		Components: &[]discordgo.MessageComponent{},
+	})
+	if err != nil {
+		logrus.WithField("error", err).Warn("Unable to provide registration result to resident")
+	}
+
+	var files []*discordgo.File
+	if result.success {
+		if files, err = ReceiptFiles(entry); err != nil {
+			logrus.WithField("error", err).Warn("Unable to render registration receipt")
+		}
+	}
+	notifyParkingRequest(request.Guest, fmt.Sprintf("<@%d> approved your parking request.", request.Resident), embed, files)
+}
+
+// RequestDenyHandler denies a parking request from the resident's DM.
+func RequestDenyHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+	_, requestId, _ := strings.Cut(interaction.MessageComponentData().CustomID, ":")
+
+	request, ok := claimParkingRequest(requestId)
+	if !ok {
+		respondToParkingRequest(session, interaction, "This request has already been handled or has expired.", nil)
+		return
+	}
+
+	embed := ParkingRequestEmbed(request)
+	embed.Color = 0xff0000
+	embed.Title = "Parking Request Denied"
+
+	respondToParkingRequest(session, interaction, "Denied.", embed)
+	notifyParkingRequest(request.Guest, fmt.Sprintf("<@%d> denied your parking request.", request.Resident), embed, nil)
+}
+
+// RequestExpireJobHandler expires a parking request the resident hasn't responded to, letting both sides know.
+func RequestExpireJobHandler(job *Job) error {
+	request, ok := claimParkingRequest(job.Target)
+	if !ok {
+		// The resident already responded
+		return nil
+	}
+
+	embed := ParkingRequestEmbed(request)
+	embed.Color = 0x808080
+	embed.Title = "Parking Request Expired"
+
+	content := "This request expired without a response."
+	_, err := session.ChannelMessageEditComplex(&discordgo.MessageEdit{
+		Channel:    request.ChannelId,
+		ID:         request.MessageId,
+		Content:    &content,
+		Embeds:     []*discordgo.MessageEmbed{embed},
+		Components: []discordgo.MessageComponent{},
+	})
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "request": request.ID}).Warn("Failed to mark parking request as expired")
+	}
+
+	notifyParkingRequest(request.Guest, fmt.Sprintf("Your parking request to <@%d> expired without a response.", request.Resident), embed, nil)
+	return nil
+}
+
+// respondToParkingRequest replaces the resident's approval message with the outcome, removing its buttons.
+func respondToParkingRequest(session *discordgo.Session, interaction *discordgo.InteractionCreate, content string, embed *discordgo.MessageEmbed) {
+	data := &discordgo.InteractionResponseData{
+		Content:    content,
+		Components: []discordgo.MessageComponent{},
+	}
+	if embed != nil {
+		data.Embeds = []*discordgo.MessageEmbed{embed}
+	}
+
+	err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: data,
+	})
+	if err != nil {
+		logrus.WithField("error", err).Error("Failed to respond to parking request")
+	}
+}
+
+// notifyParkingRequest sends a parking request update to the guest by DM, optionally with an embed and attachments.
+func notifyParkingRequest(guest int, content string, embed *discordgo.MessageEmbed, files []*discordgo.File) {
+	message := &discordgo.MessageSend{Content: content, Files: files, AllowedMentions: &discordgo.MessageAllowedMentions{}}
+	if embed != nil {
+		message.Embeds = []*discordgo.MessageEmbed{embed}
+	}
+
+	if err := SendDirectMessage(session, strconv.Itoa(guest), message); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "user": guest}).Warn("Failed to notify guest of parking request")
+	}
+}
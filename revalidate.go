@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/sirupsen/logrus"
+)
+
+// revalidationJobKey holds the identifier of the next scheduled code revalidation job.
+const revalidationJobKey = "revalidation_job"
+
+// revalidationInterval returns how often stored guest codes are revalidated.
+// This is read from CODE_REVALIDATION_HOURS, defaulting to a day. Zero disables revalidation.
+func revalidationInterval() time.Duration {
+	hours := 24.0
+	if value, parseErr := strconv.ParseFloat(os.Getenv("CODE_REVALIDATION_HOURS"), 64); parseErr == nil {
+		hours = value
+	}
+	return time.Duration(hours * float64(time.Hour))
+}
+
+// ScheduleRevalidation ensures the next code revalidation is scheduled, unless one already is or revalidation is disabled.
+func ScheduleRevalidation() {
+	interval := revalidationInterval()
+	if interval <= 0 {
+		return
+	}
+
+	if jobId, err := db.Get(revalidationJobKey).Result(); err == nil && db.ZScore(scheduleKey, jobId).Err() == nil {
+		return
+	}
+
+	jobId := ScheduleJob("revalidate", "", time.Now().Add(interval))
+	db.Set(revalidationJobKey, jobId, 0)
+}
+
+// RevalidateJobHandler checks every stored guest code with Register2Park, marking rejected codes as invalid.
+// Members are notified by DM the first time one of their codes is found to be invalid.
+func RevalidateJobHandler(job *Job) error {
+	// Schedule the next run up front, so a failure here doesn't stop revalidation altogether
+	db.Del(revalidationJobKey)
+	ScheduleRevalidation()
+
+	// Members often share the same code for a location, so each code is only checked once
+	type check struct {
+		location uint
+		code     string
+	}
+	results := make(map[check]bool)
+
+	checked, invalidated := 0, 0
+	iterator := db.Scan(0, "code:*", 100).Iterator()
+	for iterator.Next() {
+		var location uint
+		var member int
+		if _, err := fmt.Sscanf(iterator.Val(), "code:%d:%d", &location, &member); err != nil {
+			continue
+		}
+		code, ok := GetCode(int64(location), member)
+		if !ok {
+			continue
+		}
+
+		accepted, ok := results[check{location, code}]
+		if !ok {
+			var verified bool
+			verified, accepted = ValidateCode(Capture{Interaction: job.ID, Member: member}, int64(location), code)
+			if !verified {
+				// Register2Park could not be reached, so the code is left as it is
+				continue
+			}
+			results[check{location, code}] = accepted
+		}
+		checked++
+
+		previous := GetCodeStatus(int64(location), member)
+		if accepted {
+			SetCodeStatus(int64(location), member, CodeVerified)
+			continue
+		}
+
+		SetCodeStatus(int64(location), member, CodeInvalid)
+		if previous != CodeInvalid {
+			invalidated++
+			notifyInvalidCode(location, member)
+		}
+	}
+	if err := iterator.Err(); err != nil {
+		return fmt.Errorf("failed to scan stored codes: %w", err)
+	}
+
+	logrus.WithFields(logrus.Fields{"checked": checked, "invalidated": invalidated}).Info("Revalidated stored guest codes")
+	return nil
+}
+
+// notifyInvalidCode asks a member by DM for the new guest code of a location, with a button to enter it.
+func notifyInvalidCode(location uint, member int) {
+	err := SendDirectMessage(session, strconv.Itoa(member), &discordgo.MessageSend{
+		Embeds: []*discordgo.MessageEmbed{
+			{
+				Title:       "Guest Code Changed",
+				Color:       0xffa500,
+				Description: fmt.Sprintf("Your stored guest code at \"%s\" is no longer accepted. It may have been changed by the property manager; please enter the new one.", GetLocationName(location)),
+				Footer: &discordgo.MessageEmbedFooter{
+					Text: GetFooterText(),
+				},
+			},
+		},
+		Components: []discordgo.MessageComponent{
+			discordgo.ActionsRow{
+				Components: []discordgo.MessageComponent{
+					discordgo.Button{
+						Label:    "Enter new code",
+						Style:    discordgo.PrimaryButton,
+						CustomID: fmt.Sprintf("code-update:%d", location),
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "user": member, "location": location}).Warn("Failed to notify user of invalid code")
+	}
+}
+
+// CodeUpdateHandler opens a modal for entering the new guest code of a location.
+func CodeUpdateHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+	_, locationId, _ := strings.Cut(interaction.MessageComponentData().CustomID, ":")
+
+	err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseModal,
+		Data: &discordgo.InteractionResponseData{
+			CustomID: "code-update:" + locationId,
+			Title:    "New Guest Code",
+			Components: []discordgo.MessageComponent{
+				discordgo.ActionsRow{
+					Components: []discordgo.MessageComponent{
+						discordgo.TextInput{
+							CustomID:  "code",
+							Label:     "Guest Code",
+							Style:     discordgo.TextInputShort,
+							Required:  true,
+							MinLength: 4,
+							MaxLength: 12,
+						},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		logrus.WithField("error", err).Error("Failed to present code modal")
+	}
+}
+
+// CodeUpdateModalHandler validates and stores the new guest code entered for a location.
+func CodeUpdateModalHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+	data := interaction.ModalSubmitData()
+	_, locationId, _ := strings.Cut(data.CustomID, ":")
+	code := strings.TrimSpace(data.Components[0].(*discordgo.ActionsRow).Components[0].(*discordgo.TextInput).Value)
+
+	location, parseErr := strconv.ParseInt(locationId, 10, 64)
+	if parseErr != nil || !LocationExists(location) {
+		HandleError(session, interaction, parseErr, ":x: The location for this code no longer exists.")
+		return
+	}
+
+	userId, parseErr := strconv.Atoi(GetUser(interaction).ID)
+	if parseErr != nil {
+		HandleError(session, interaction, parseErr, "Error occurred while parsing user id")
+		return
+	}
+
+	submitCode(session, interaction, location, userId, code)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	scheduleKey       = "schedule"       // Sorted set of job IDs, scored by the unix time they are due
+	schedulerInterval = 15 * time.Second // How often the schedule is checked for due jobs
+	maxJobAttempts    = 6                // Jobs are dropped after failing this many times
+	jobRetryBase      = time.Minute      // The delay before the first retry, doubled with every subsequent failure
+)
+
+// ScheduleJob persists a new job that will be run by the scheduler at the given time.
+// The job's identifier is returned.
+func ScheduleJob(kind string, target string, at time.Time) string {
+	job := Job{ID: NewIdentifier(), Kind: kind, Target: target}
+	storeJob(job, at)
+
+	log.WithFields(log.Fields{"job": job.ID, "kind": kind, "target": target, "at": at}).Debug("Scheduled job")
+	return job.ID
+}
+
+// CancelJob removes a job from the schedule.
+func CancelJob(id string) {
+	db.ZRem(scheduleKey, id)
+	db.Del(fmt.Sprintf("job:%s", id))
+}
+
+func storeJob(job Job, at time.Time) {
+	storeJSON(fmt.Sprintf("job:%s", job.ID), job, 0)
+	db.ZAdd(scheduleKey, redis.Z{Score: float64(at.Unix()), Member: job.ID})
+}
+
+// FinalAttempt returns true if the job will not be retried should the current attempt fail.
+func (job *Job) FinalAttempt() bool {
+	return job.Attempts+1 >= maxJobAttempts
+}
+
+// RunScheduler runs due jobs until the stop channel is closed.
+// As jobs are persisted in Redis, anything scheduled before a restart is picked up once the scheduler starts again.
+func RunScheduler(stop <-chan struct{}) {
+	ticker := time.NewTicker(schedulerInterval)
+	defer ticker.Stop()
+
+	log.Info("Scheduler started")
+	for {
+		select {
+		case <-stop:
+			log.Info("Scheduler stopped")
+			return
+		case <-ticker.C:
+			runDueJobs()
+		}
+	}
+}
+
+// runDueJobs runs every job whose time has arrived, rescheduling failed jobs with exponential backoff.
+// Retries of jobs with a deadline are brought forward so they still run by then.
+func runDueJobs() {
+	due, err := db.ZRangeByScore(scheduleKey, redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(time.Now().Unix(), 10),
+	}).Result()
+	if err != nil {
+		log.WithField("error", err).Error("Failed to retrieve due jobs")
+		return
+	}
+
+	for _, id := range due {
+		// Removing the job from the schedule claims it, so it is only ever run once
+		if db.ZRem(scheduleKey, id).Val() == 0 {
+			continue
+		}
+
+		key := fmt.Sprintf("job:%s", id)
+		var job Job
+		if !loadJSON(key, &job) {
+			log.WithField("job", id).Warn("Scheduled job has no data")
+			continue
+		}
+
+		logger := log.WithFields(log.Fields{"job": job.ID, "kind": job.Kind, "target": job.Target, "attempts": job.Attempts})
+
+		handler, ok := jobHandlers[job.Kind]
+		if !ok {
+			logger.Warn("No handler for scheduled job")
+			db.Del(key)
+			continue
+		}
+
+		err := runJob(handler, &job)
+		if err == nil {
+			logger.Debug("Job complete")
+			db.Del(key)
+			continue
+		}
+
+		job.Attempts++
+		if job.Attempts >= maxJobAttempts {
+			logger.WithField("error", err).Error("Job failed too many times, giving up")
+			db.Del(key)
+			continue
+		}
+
+		delay := jobRetryBase * time.Duration(1<<(job.Attempts-1))
+		if !job.Deadline.IsZero() {
+			delay = max(min(delay, time.Until(job.Deadline)), 0)
+		}
+		logger.WithFields(log.Fields{"error": err, "delay": delay}).Warn("Job failed, retrying later")
+		storeJob(job, time.Now().Add(delay))
+	}
+}
+
+// runJob runs a job's handler, converting any panic into an error so the scheduler keeps running.
+func runJob(handler func(job *Job) error, job *Job) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("job panicked: %v", recovered)
+		}
+	}()
+
+	return handler(job)
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/samber/lo"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	codeAuditLimit   = 200 // The maximum number of uses kept in a shared code's audit trail
+	codeAuditDisplay = 20  // The number of uses displayed by /shared-code audit
+)
+
+// errSharedCodeUnavailable is returned when a shared code has been revoked, or the member has lost access to it.
+var errSharedCodeUnavailable = errors.New("shared guest code is no longer available")
+
+// GetSharedCode returns the guest code shared within a guild for a given property.
+func GetSharedCode(guild string, propertyId uint) (SharedCode, bool) {
+	var shared SharedCode
+	ok := loadJSON(fmt.Sprintf("shared_code:%s:%d", guild, propertyId), &shared)
+	return shared, ok
+}
+
+// StoreSharedCode stores (or replaces) a guest code shared within a guild.
+func StoreSharedCode(shared SharedCode) {
+	storeJSON(fmt.Sprintf("shared_code:%s:%d", shared.Guild, shared.PropertyId), shared, 0)
+}
+
+// RemoveSharedCode stops sharing a guest code within a guild. The audit trail is kept.
+func RemoveSharedCode(guild string, propertyId uint) {
+	db.Del(fmt.Sprintf("shared_code:%s:%d", guild, propertyId))
+}
+
+// GetSharedCodes returns every guest code shared within a guild.
+func GetSharedCodes(guild string) []SharedCode {
+	codes := make([]SharedCode, 0)
+
+	iterator := db.Scan(0, fmt.Sprintf("shared_code:%s:*", guild), 100).Iterator()
+	for iterator.Next() {
+		var shared SharedCode
+		if loadJSON(iterator.Val(), &shared) {
+			codes = append(codes, shared)
+		}
+	}
+	if err := iterator.Err(); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "guild": guild}).Error("Failed to scan shared codes")
+	}
+
+	slices.SortFunc(codes, func(a, b SharedCode) int {
+		return strings.Compare(GetLocationName(a.PropertyId), GetLocationName(b.PropertyId))
+	})
+	return codes
+}
+
+// Allows returns true if a guild member may use the shared code.
+func (shared SharedCode) Allows(member *discordgo.Member) bool {
+	if member == nil || member.User == nil {
+		return false
+	}
+	if member.User.ID == strconv.Itoa(shared.Owner) || slices.Contains(shared.Members, member.User.ID) {
+		return true
+	}
+	return lo.Some(member.Roles, shared.Roles)
+}
+
+// RecordCodeUse appends an entry to the audit trail of the code shared within a guild for a given property.
+func RecordCodeUse(guild string, propertyId uint, use CodeUse) {
+	encoded, err := json.Marshal(use)
+	if err != nil {
+		logrus.WithField("error", err).Error("Failed to encode code use")
+		return
+	}
+
+	key := fmt.Sprintf("shared_code_audit:%s:%d", guild, propertyId)
+	db.LPush(key, encoded)
+	db.LTrim(key, 0, codeAuditLimit-1)
+}
+
+// GetCodeUses returns the audit trail of the code shared within a guild for a given property, newest first.
+func GetCodeUses(guild string, propertyId uint) []CodeUse {
+	key := fmt.Sprintf("shared_code_audit:%s:%d", guild, propertyId)
+	uses := make([]CodeUse, 0)
+
+	for _, raw := range db.LRange(key, 0, -1).Val() {
+		var use CodeUse
+		if err := json.Unmarshal([]byte(raw), &use); err != nil {
+			logrus.WithFields(logrus.Fields{"error": err, "guild": guild}).Warn("Failed to decode code use")
+			continue
+		}
+		uses = append(uses, use)
+	}
+
+	return uses
+}
+
+// SharedCodeFor returns the guest code shared within a guild for a property if the member is allowed to use it.
+// The owner's currently stored code is used, so the share follows any changes they make to it.
+func SharedCodeFor(guild string, propertyId uint, member *discordgo.Member) (string, bool) {
+	shared, ok := GetSharedCode(guild, propertyId)
+	if !ok || !shared.Allows(member) {
+		return "", false
+	}
+
+	code, ok := GetCode(int64(propertyId), shared.Owner)
+	if !ok {
+		return "", false
+	}
+
+	return code, true
+}
+
+// RecordSharedCodeUse adds a registration made with a guild's shared code to its audit trail.
+// Uses are only recorded once Register2Park has responded, so abandoned or failed attempts aren't included.
+func RecordSharedCodeUse(guild string, propertyId uint, member int, action string) {
+	RecordCodeUse(guild, propertyId, CodeUse{Member: member, Action: action, At: time.Now()})
+}
+
+// SubmissionCode returns the guest code to repeat a submission with.
+// Codes shared within a guild are looked up again, so revoked shares (or members who lost access) can no longer use them.
+func SubmissionCode(submission Submission) (string, error) {
+	if submission.Event != "" {
+		card, ok := GetEventCard(submission.Event)
+		if !ok || card.Closed() {
+			return "", errSharedCodeUnavailable
+		}
+		return card.GuestCode, nil
+	}
+	if submission.SharedBy == "" {
+		return submission.GuestCode, nil
+	}
+
+	member, err := session.GuildMember(submission.SharedBy, strconv.Itoa(submission.Member))
+	if err != nil {
+		return "", fmt.Errorf("failed to retrieve guild member: %w", err)
+	}
+
+	code, ok := SharedCodeFor(submission.SharedBy, submission.PropertyId, member)
+	if !ok {
+		return "", errSharedCodeUnavailable
+	}
+	return code, nil
+}
+
+var SharedCodeCommandDefinition = &discordgo.ApplicationCommand{
+	Name:        "shared-code",
+	Description: "Share your guest codes with this server, without revealing them",
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "share",
+			Description: "Allow a role or member to register with your stored guest code",
+			Options: []*discordgo.ApplicationCommandOption{
+				codeLocationOption,
+				{
+					Type:        discordgo.ApplicationCommandOptionRole,
+					Name:        "role",
+					Description: "The role to share the code with",
+					Required:    false,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionUser,
+					Name:        "member",
+					Description: "The member to share the code with",
+					Required:    false,
+				},
+			},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "revoke",
+			Description: "Stop sharing your guest code for a location",
+			Options:     []*discordgo.ApplicationCommandOption{codeLocationOption},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "audit",
+			Description: "View every use of a guest code you've shared",
+			Options:     []*discordgo.ApplicationCommandOption{codeLocationOption},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "list",
+			Description: "List the guest codes shared with you in this server",
+		},
+	},
+}
+
+func SharedCodeCommandHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+	log := logrus.WithFields(logrus.Fields{
+		"interaction": interaction.ID,
+		"user":        GetUser(interaction).ID,
+		"command":     "shared-code",
+	})
+
+	if interaction.GuildID == "" {
+		HandleError(session, interaction, nil, ":x: Guest codes can only be shared within a server.")
+		return
+	}
+
+	data := interaction.ApplicationCommandData()
+	subcommand := data.Options[0]
+
+	switch interaction.Type {
+
+	case discordgo.InteractionApplicationCommand:
+		userId, parseErr := strconv.Atoi(GetUser(interaction).ID)
+		if parseErr != nil {
+			HandleError(session, interaction, parseErr, "Error occurred while parsing user id")
+			return
+		}
+
+		if subcommand.Name == "list" {
+			respondToSharedCode(session, interaction, DescribeSharedCodes(GetSharedCodes(interaction.GuildID), interaction.Member))
+			return
+		}
+
+		// Every other subcommand operates on a single location
+		locationId, _ := strconv.Atoi(subcommand.Options[0].StringValue())
+		if !LocationExists(int64(locationId)) {
+			HandleError(session, interaction, nil, "The location provided does not exist.")
+			return
+		}
+		propertyId := uint(locationId)
+		locationName := GetLocationName(propertyId)
+
+		shared, exists := GetSharedCode(interaction.GuildID, propertyId)
+		if exists && shared.Owner != userId {
+			HandleError(session, interaction, nil, fmt.Sprintf(":x: The guest code for \"%s\" in this server is shared by <@%d>.", locationName, shared.Owner))
+			return
+		}
+
+		switch subcommand.Name {
+		case "share":
+			if _, ok := GetCode(int64(locationId), userId); !ok {
+				HandleError(session, interaction, nil, fmt.Sprintf(":x: You have no guest code stored for \"%s\". Set one with `/code set` first.", locationName))
+				return
+			}
+
+			if !exists {
+				shared = SharedCode{Guild: interaction.GuildID, PropertyId: propertyId, Owner: userId, SharedAt: time.Now()}
+			}
+
+			granted := make([]string, 0, 2)
+			for _, option := range subcommand.Options[1:] {
+				switch option.Name {
+				case "role":
+					role := option.RoleValue(nil, "")
+					shared.Roles = lo.Uniq(append(shared.Roles, role.ID))
+					granted = append(granted, fmt.Sprintf("<@&%s>", role.ID))
+				case "member":
+					user := option.UserValue(nil)
+					shared.Members = lo.Uniq(append(shared.Members, user.ID))
+					granted = append(granted, fmt.Sprintf("<@%s>", user.ID))
+				}
+			}
+			if len(granted) == 0 {
+				HandleError(session, interaction, nil, ":x: Choose a role or member to share the code with.")
+				return
+			}
+
+			StoreSharedCode(shared)
+			RecordCodeUse(interaction.GuildID, propertyId, CodeUse{Member: userId, Action: "share with " + strings.Join(granted, ", "), At: time.Now()})
+			log.WithFields(logrus.Fields{"location": locationId, "roles": shared.Roles, "members": shared.Members}).Debug("Shared guest code")
+			respondToSharedCode(session, interaction, fmt.Sprintf("Your guest code at \"%s\" is now shared with %s. They can register without seeing it.",
+				locationName, strings.Join(granted, " and ")))
+
+		case "revoke":
+			if !exists {
+				HandleError(session, interaction, nil, fmt.Sprintf(":x: You aren't sharing a guest code for \"%s\" in this server.", locationName))
+				return
+			}
+
+			RemoveSharedCode(interaction.GuildID, propertyId)
+			RecordCodeUse(interaction.GuildID, propertyId, CodeUse{Member: userId, Action: "revoke", At: time.Now()})
+			respondToSharedCode(session, interaction, fmt.Sprintf("Your guest code at \"%s\" is no longer shared.", locationName))
+
+		case "audit":
+			uses := GetCodeUses(interaction.GuildID, propertyId)
+			if len(uses) == 0 {
+				HandleError(session, interaction, nil, fmt.Sprintf(":x: There is no record of a guest code shared for \"%s\" in this server.", locationName))
+				return
+			}
+
+			// Once revoked, only a member who shared the code can still view its audit trail
+			if !exists && !slices.ContainsFunc(uses, func(use CodeUse) bool {
+				return use.Member == userId && strings.HasPrefix(use.Action, "share")
+			}) {
+				HandleError(session, interaction, nil, ":x: Only the member who shared the code can view its audit trail.")
+				return
+			}
+
+			respondToSharedCode(session, interaction, DescribeCodeUses(locationName, uses))
+		}
+
+	case discordgo.InteractionApplicationCommandAutocomplete:
+		var choices []*discordgo.ApplicationCommandOptionChoice
+
+		focusedOption, _ := lo.Find(subcommand.Options, func(option *discordgo.ApplicationCommandInteractionDataOption) bool {
+			return option.Focused
+		})
+		if focusedOption != nil && focusedOption.Name == codeLocationOption.Name {
+			choices = LocationChoices(interaction, focusedOption.StringValue())
+		}
+
+		err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+			Data: &discordgo.InteractionResponseData{
+				Choices: choices,
+			},
+		})
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// respondToSharedCode responds to a /shared-code command with a hidden message, without pinging anyone mentioned.
+func respondToSharedCode(session *discordgo.Session, interaction *discordgo.InteractionCreate, content string) {
+	err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content:         content,
+			Flags:           discordgo.MessageFlagsEphemeral,
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		},
+	})
+	if err != nil {
+		logrus.WithField("error", err).Error("Failed to respond to shared code command")
+	}
+}
+
+// DescribeSharedCodes lists the shared codes a guild member may use, without revealing them.
+func DescribeSharedCodes(codes []SharedCode, member *discordgo.Member) string {
+	lines := make([]string, 0, len(codes))
+	for _, shared := range codes {
+		if shared.Allows(member) {
+			lines = append(lines, fmt.Sprintf("**%s**, shared by <@%d>", GetLocationName(shared.PropertyId), shared.Owner))
+		}
+	}
+
+	if len(lines) == 0 {
+		return "No guest codes have been shared with you in this server."
+	}
+	return "You can register at these locations with a shared guest code:\n" + strings.Join(lines, "\n")
+}
+
+// DescribeCodeUses summarizes the most recent entries of a shared code's audit trail.
+func DescribeCodeUses(locationName string, uses []CodeUse) string {
+	lines := make([]string, 0, codeAuditDisplay+1)
+	lines = append(lines, fmt.Sprintf("**Shared guest code for \"%s\"** (%d use%s recorded)", locationName, len(uses), Plural(len(uses))))
+
+	for _, use := range uses[:min(len(uses), codeAuditDisplay)] {
+		lines = append(lines, fmt.Sprintf("<t:%d:f> <@%d> %s", use.At.Unix(), use.Member, use.Action))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -1,19 +1,19 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/davecgh/go-spew/spew"
 	"github.com/samber/lo"
 	log "github.com/sirupsen/logrus"
 )
 
 func RegisterModalHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
-	// TODO: Pull in all parameters from the form
-	// TODO: Pull in all hidden parameters form database
-	// TODO: Pull in resident ID from database
-
 	err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseChannelMessageWithSource,
 		Data: &discordgo.InteractionResponseData{
@@ -31,7 +31,7 @@ func RegisterModalHandler(session *discordgo.Session, interaction *discordgo.Int
 		return inner.CustomID, inner
 	})
 
-	log.Infof("dataByCustomID: %+v", dataByCustomID)
+	log.WithField("fields", lo.Keys(dataByCustomID)).Debug("Received registration form")
 
 	// Collect the form parameters provided by the user
 	formParams := map[string]string{}
@@ -60,45 +60,571 @@ func RegisterModalHandler(session *discordgo.Session, interaction *discordgo.Int
 	}
 	context := contextInterface.(*RegisterContext)
 
+	email := dataByCustomID["email"].(*discordgo.TextInput).Value
+	CompleteRegistration(session, interaction, context, formParams, email)
+
+	// TODO: Validate license plate
+}
+
+// FetchForm retrieves the registration form for a property, using the VIP form when a guest code is given.
+func FetchForm(capture Capture, propertyId uint, guestCode string) GetFormResult {
+	if guestCode != "" {
+		return GetVipForm(capture, propertyId, guestCode)
+	}
+	return GetForm(capture, propertyId)
+}
+
+// NewRegisterContext builds the registration context for a form retrieved for the given property.
+func NewRegisterContext(form GetFormResult, propertyId uint, guestCode string) (*RegisterContext, error) {
+	// Non-VIP forms may not carry a resident profile
+	var residentProfileId uint64
+	if form.residentProfileId != "" {
+		var parseErr error
+		residentProfileId, parseErr = strconv.ParseUint(form.residentProfileId, 10, 64)
+		if parseErr != nil {
+			return nil, parseErr
+		}
+	}
+
+	return &RegisterContext{
+		hiddenKeys: form.hiddenInputs,
+		propertyId: propertyId,
+		requiredFormKeys: lo.Map(form.fields, func(field Field, _ int) string {
+			return field.id
+		}),
+		residentId: uint(residentProfileId),
+		guestCode:  guestCode,
+	}, nil
+}
+
+// shareFrom marks the context's guest code as shared within a guild, so it isn't persisted with the submission.
+// Its use is audited (as the given action) once the vehicle has been registered.
+func (context *RegisterContext) shareFrom(guild string, action string) {
+	context.sharedGuild = guild
+	context.sharedAction = action
+	context.guestCode = ""
+}
+
+// redeem marks the context as redeeming a visitor invite, filling in the resident's values.
+// The resident's guest code isn't persisted with the visitor's submission.
+func (context *RegisterContext) redeem(invite Invite) {
+	context.invite = invite.ID
+	context.presetValues = invite.Values
+	context.guestCode = ""
+}
+
+// valuesAt adapts values entered at one property for registering at another. A member's apartment (and anything the
+// form hid) belongs to the property it was entered for, so the apartment stored for the new property is used instead.
+func valuesAt(values map[string]string, hiddenKeys []string, propertyId uint, member int) map[string]string {
+	adapted := lo.OmitByKeys(values, append([]string{"vehicleApt"}, hiddenKeys...))
+	if apartment, ok := GetApartment(propertyId, member); ok {
+		adapted["vehicleApt"] = apartment
+	}
+	return adapted
+}
+
+// PresentRegistration continues a registration once the form has been retrieved.
+// If the values provided cover every field of the form, the vehicle is registered immediately.
+// Otherwise, the user is presented with the registration modal, pre-filled with whatever values are available.
+func PresentRegistration(session *discordgo.Session, interaction *discordgo.InteractionCreate, context *RegisterContext, form GetFormResult, values map[string]string, email string) {
+	// The saved values cover every field, so the modal can be skipped entirely
+	if len(values) > 0 && len(MissingFields(form, values)) == 0 {
+		log.WithFields(log.Fields{"interaction": interaction.ID, "propertyId": context.propertyId}).Debug("Saved values satisfy form, registering directly")
+
+		err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: "Registering your vehicle, please wait.",
+				Flags:   discordgo.MessageFlagsEphemeral,
+			},
+		})
+		if err != nil {
+			log.WithField("error", err).Error("Failed to acknowledge registration")
+			return
+		}
+
+		// Only the values the form asks for are submitted
+		formParams := make(map[string]string, len(form.fields))
+		for _, field := range form.fields {
+			if value, ok := values[field.id]; ok {
+				formParams[field.id] = value
+			}
+		}
+
+		CompleteRegistration(session, interaction, context, formParams, email)
+		return
+	}
+
+	// The ID of the original interaction is used as the identifier for the registration context (uint64)
+	registerIdentifier, parseErr := strconv.ParseUint(interaction.ID, 10, 64)
+	if parseErr != nil {
+		HandleError(session, interaction, parseErr, "Error occurred while parsing interaction message identifier")
+		return
+	}
+
+	// Log the registration context at debug
+	log.WithFields(log.Fields{
+		"registerIdentifier": registerIdentifier,
+		"propertyId":         context.propertyId,
+		"residentId":         context.residentId,
+	}).Debug("Storing registration context")
+
+	// Store the registration context for later use
+	SubmissionContexts.Set(registerIdentifier, context, time.Hour)
+
+	// Convert the form into message components for a modal presented to the user, pre-filling any saved values
+	registrationFormComponents := FormToModalComponents(form, values)
+	registrationFormComponents = append(registrationFormComponents, discordgo.ActionsRow{
+		Components: []discordgo.MessageComponent{
+			discordgo.TextInput{
+				CustomID:  "email",
+				Label:     "Email Address (for confirmation)",
+				Style:     discordgo.TextInputShort,
+				Required:  false,
+				MinLength: 1,
+				Value:     email,
+			},
+		},
+	})
+
+	response := discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseModal,
+		Data: &discordgo.InteractionResponseData{
+			CustomID:   "register:" + interaction.ID,
+			Title:      "Vehicle Registration",
+			Components: registrationFormComponents,
+		},
+	}
+
+	err := session.InteractionRespond(interaction.Interaction, &response)
+	if err != nil {
+		log.WithField("dump", spew.Sdump(response)).Error(err)
+	}
+}
+
+// CompleteRegistration registers the vehicle described by the form parameters and reports the outcome to the user.
+// The interaction must already have been responded to, as the original response is edited to display the result.
+// Approved registrations have their form values stored as a vehicle profile for later re-use.
+func CompleteRegistration(session *discordgo.Session, interaction *discordgo.InteractionCreate, context *RegisterContext, formParams map[string]string, email string) {
+	for key, value := range context.presetValues {
+		formParams[key] = value
+	}
+
+	// Visitors redeeming an invite use up one of its registrations
+	var invite Invite
+	var inviteUses int
+	if context.invite != "" {
+		var ok bool
+		if invite, ok = GetInvite(context.invite); !ok {
+			HandleError(session, interaction, nil, fmt.Sprintf(":x: This %s.", errInviteExpired))
+			return
+		}
+
+		var err error
+		if inviteUses, err = useInvite(invite); err != nil {
+			HandleError(session, interaction, nil, fmt.Sprintf(":x: This %s.", err))
+			return
+		}
+	}
+
 	// Register the vehicle
-	result, err := RegisterVehicle(formParams, context.propertyId, context.residentId, context.hiddenKeys)
+	result, err := RegisterVehicle(NewCapture(interaction), formParams, context.propertyId, context.residentId, context.hiddenKeys)
 
 	if err != nil {
+		if context.invite != "" {
+			refundInvite(invite)
+		}
 		HandleError(session, interaction, err, "Failed to register vehicle")
 		return
 	}
 
-	// Send email confirmation if an email was provided
-	email := dataByCustomID["email"].(*discordgo.TextInput).Value
-	if email != "" {
-		success, err := RegisterEmailConfirmation(email, result.vehicleId, strconv.Itoa(int(context.propertyId)))
+	// Record the attempt before anything else can fail
+	userId, parseErr := strconv.Atoi(GetUser(interaction).ID)
+	if parseErr != nil {
+		HandleError(session, interaction, parseErr, "Error occurred while parsing user id")
+		return
+	}
+	if context.sharedGuild != "" {
+		RecordSharedCodeUse(context.sharedGuild, context.propertyId, userId, context.sharedAction)
+	}
+
+	// Registrations made on someone else's behalf belong to them, but are recorded in both histories
+	member, registeredBy := userId, 0
+	if context.onBehalfOf != 0 {
+		member, registeredBy = context.onBehalfOf, userId
+	}
+
+	entry := RecordRegistration(interaction.ID, member, registeredBy, context.propertyId, formParams, result)
+	if context.invite != "" {
+		notifyInviteUse(invite, inviteUses, userId, entry)
+	}
+	if context.event != "" {
+		RecordEventRegistration(context.event, member, result.success)
+	}
+
+	// Send email confirmation if an email was provided. The vehicle is registered regardless, so failing to do so
+	// mustn't keep the result from being shown.
+	emailFailed := false
+	if email != "" && result.success {
+		success, err := RegisterEmailConfirmation(NewCapture(interaction).at(context.propertyId), email, result.vehicleId, strconv.Itoa(int(context.propertyId)))
+		if err != nil || !success {
+			log.WithFields(log.Fields{"error": err, "interaction": interaction.ID}).Warn("Failed to send email confirmation")
+			emailFailed = true
+		}
+	}
+
+	// Preserve the details entered so they can be re-used for any other location, unless the member opted out
+	neverStore := NeverStore(member)
+	if result.success && !neverStore {
+		// Values supplied by someone else (i.e. a resident's apartment) were never shown to the member, so aren't kept
+		omitted := lo.Keys(context.presetValues)
+		if context.onBehalfOf != 0 {
+			// Neither is anything else the form hides from the registrar
+			omitted = append(omitted, context.hiddenKeys...)
+		}
+
+		StoreVehicle(member, VehicleProfile{
+			Plate:  formParams["vehicleLicensePlate"],
+			Values: lo.OmitByKeys(formParams, omitted),
+			Email:  email,
+		})
+
+		// A resident's apartment is remembered for approving their guests' parking requests
+		if apartment := formParams["vehicleApt"]; apartment != "" && context.invite == "" && !NeverStore(userId) {
+			StoreApartment(context.propertyId, userId, apartment)
+		}
+	}
+
+	// Keep the submission around so the buttons below can repeat it
+	submission := Submission{
+		Member:     member,
+		PropertyId: context.propertyId,
+		ResidentId: context.residentId,
+		HiddenKeys: context.hiddenKeys,
+		GuestCode:  context.guestCode,
+		SharedBy:   context.sharedGuild,
+		Event:      context.event,
+		Values:     formParams,
+		Email:      email,
+	}
+	if result.success {
+		submission.Expiry = result.expiry()
+	}
+	components := []discordgo.MessageComponent{RegistrationResultButtons(interaction.ID, result.success)}
+
+	// Invites can't be repeated, so visitors aren't offered the buttons (or reminders) to do so.
+	// The same goes for members who opted out, as the submission would have to be kept.
+	if context.invite == "" && !neverStore {
+		if context.onBehalfOf != 0 {
+			// Members registered for are reminded, but can't repeat it, as that would use the registrar's guest code
+			// without their consent. Nothing of the registrar's is kept with the submission either.
+			submission.RegisteredBy = userId
+			submission.GuestCode = ""
+			submission.Values = lo.OmitByKeys(formParams, append([]string{"vehicleApt"}, context.hiddenKeys...))
+			components = []discordgo.MessageComponent{}
+		}
+		StoreSubmission(interaction.ID, submission)
+		ScheduleExpiryReminder(interaction.ID, submission)
+	} else {
+		components = []discordgo.MessageComponent{}
+	}
+
+	embed := RegistrationResultEmbed(result, context.propertyId, formParams)
+
+	// Attach a receipt that can be shown on a phone, rather than reading the code out of the embed
+	var files []*discordgo.File
+	if result.success {
+		var receiptErr error
+		if files, receiptErr = ReceiptFiles(entry); receiptErr != nil {
+			log.WithField("error", receiptErr).Warn("Unable to render registration receipt")
+		}
+	}
+
+	content := ""
+	if context.onBehalfOf != 0 {
+		// The member registered for receives the confirmation & receipt
+		err := SendDirectMessage(session, strconv.Itoa(member), &discordgo.MessageSend{
+			Content:         fmt.Sprintf("<@%d> registered your vehicle.", userId),
+			Embeds:          []*discordgo.MessageEmbed{embed},
+			Components:      components,
+			Files:           files,
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		})
 		if err != nil {
-			HandleError(session, interaction, err, "Failed to send email confirmation")
-			return
+			log.WithFields(log.Fields{"error": err, "user": member}).Warn("Failed to send registration result to member registered for")
+			content = fmt.Sprintf(":warning: <@%d> couldn't be messaged the confirmation, so please pass it on.", member)
+		} else {
+			content = fmt.Sprintf("<@%d> has been sent the confirmation.", member)
+			components, files = []discordgo.MessageComponent{}, nil
 		}
+	}
+	if emailFailed {
+		content = strings.TrimSpace(content + "\n:warning: The confirmation email couldn't be sent, so keep the confirmation code below.")
+	}
 
-		if !success {
-			HandleError(session, interaction, nil, "Failed to send email confirmation")
-			return
+	_, err = session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{
+		Content:         &content,
+		Embeds:          &[]*discordgo.MessageEmbed{embed},
+		Components:      &components,
+		Files:           files,
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	})
+	if err != nil {
+		log.WithField("error", err).Warn("Unable to provide registration result to user.")
+	}
+}
+
+// RegistrationResultEmbed builds the embed describing the outcome of a registration attempt.
+func RegistrationResultEmbed(result *RegistrationResult, propertyId uint, formParams map[string]string) *discordgo.MessageEmbed {
+	embed := &discordgo.MessageEmbed{
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: GetFooterText(),
+		},
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Location", Value: GetLocationName(propertyId), Inline: true},
+			{Name: "License Plate", Value: strings.ToUpper(formParams["vehicleLicensePlate"]), Inline: true},
+		},
+	}
+
+	if result.success {
+		embed.Color = 0x00ff00
+		embed.Title = "Registration Approved"
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name: "Confirmation Code", Value: result.confirmationCode, Inline: true,
+		})
+	} else {
+		embed.Color = 0xff0000
+		embed.Title = "Registration Denied"
+	}
+
+	if !result.timestamp.IsZero() {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name: "Date/Time", Value: result.timestamp.Format("Jan 2, 2006 3:04 PM"),
+		})
+	}
+
+	return embed
+}
+
+// RegistrationResultButtons builds the buttons attached to a registration result, allowing the submission to be repeated.
+// Approved registrations can additionally be renewed automatically or downloaded as a calendar event.
+func RegistrationResultButtons(submissionId string, approved bool) discordgo.ActionsRow {
+	row := discordgo.ActionsRow{
+		Components: []discordgo.MessageComponent{
+			discordgo.Button{
+				Label:    "Register again",
+				Style:    discordgo.PrimaryButton,
+				CustomID: "register-again:" + submissionId,
+			},
+			discordgo.Button{
+				Label:    "Register elsewhere",
+				Style:    discordgo.SecondaryButton,
+				CustomID: "register-elsewhere:" + submissionId,
+			},
+		},
+	}
+
+	if approved {
+		row.Components = append(row.Components, discordgo.Button{
+			Label:    "Auto-renew",
+			Style:    discordgo.SuccessButton,
+			CustomID: "auto-renew:" + submissionId,
+		}, discordgo.Button{
+			Label:    "Add to calendar",
+			Style:    discordgo.SecondaryButton,
+			CustomID: "calendar:" + submissionId,
+		})
+	}
+
+	return row
+}
+
+// getOwnSubmission returns the submission referenced by a component's custom ID ('handler:submissionId').
+// An error is shown to the user if the submission has expired or belongs to someone else.
+func getOwnSubmission(session *discordgo.Session, interaction *discordgo.InteractionCreate, customId string) (Submission, bool) {
+	_, submissionId, _ := strings.Cut(customId, ":")
+
+	submission, ok := GetSubmission(submissionId)
+	if !ok {
+		HandleError(session, interaction, nil, ":x: This registration is too old to be repeated.")
+		return submission, false
+	}
+
+	if strconv.Itoa(submission.Member) != GetUser(interaction).ID {
+		HandleError(session, interaction, nil, ":x: This registration belongs to someone else.")
+		return submission, false
+	}
+
+	return submission, true
+}
+
+// getRepeatableSubmission returns the submission referenced by a component's custom ID, if the user may repeat it.
+// Registrations made on the user's behalf can't be, as they used someone else's guest code.
+func getRepeatableSubmission(session *discordgo.Session, interaction *discordgo.InteractionCreate, customId string) (Submission, bool) {
+	submission, ok := getOwnSubmission(session, interaction, customId)
+	if ok && submission.RegisteredBy != 0 {
+		HandleError(session, interaction, nil, ":x: This registration was made for you by someone else, so can't be repeated.")
+		return submission, false
+	}
+	return submission, ok
+}
+
+// RegisterAgainHandler repeats a previous registration at the same property with the same values.
+func RegisterAgainHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+	submission, ok := getRepeatableSubmission(session, interaction, interaction.MessageComponentData().CustomID)
+	if !ok {
+		return
+	}
+
+	code, err := SubmissionCode(submission)
+	if errors.Is(err, errSharedCodeUnavailable) {
+		HandleError(session, interaction, nil, ":x: The guest code shared with you for this location is no longer available.")
+		return
+	} else if err != nil {
+		HandleError(session, interaction, err, "Failed to retrieve the shared guest code")
+		return
+	}
+
+	form := FetchForm(NewCapture(interaction), submission.PropertyId, code)
+	if form.err != nil {
+		HandleError(session, interaction, form.err, ":x: Failed to retrieve the registration form.")
+		return
+	} else if form.requireGuestCode {
+		HandleError(session, interaction, nil, ":x: This location requires a guest code and the one used previously is no longer valid.")
+		return
+	}
+
+	context, err := NewRegisterContext(form, submission.PropertyId, code)
+	if err != nil {
+		HandleError(session, interaction, err, "Error occurred while parsing resident profile identifier")
+		return
+	}
+	if submission.SharedBy != "" {
+		context.shareFrom(submission.SharedBy, "register again")
+	}
+	if submission.Event != "" {
+		context.attend(submission.Event)
+	}
+
+	PresentRegistration(session, interaction, context, form, submission.Values, submission.Email)
+}
+
+// RegisterElsewhereHandler asks the user which property a previous registration should be repeated at.
+func RegisterElsewhereHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+	_, submissionId, _ := strings.Cut(interaction.MessageComponentData().CustomID, ":")
+	if _, ok := getRepeatableSubmission(session, interaction, interaction.MessageComponentData().CustomID); !ok {
+		return
+	}
+
+	err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseModal,
+		Data: &discordgo.InteractionResponseData{
+			CustomID: "register-elsewhere:" + submissionId,
+			Title:    "Register Elsewhere",
+			Components: []discordgo.MessageComponent{
+				discordgo.ActionsRow{
+					Components: []discordgo.MessageComponent{
+						discordgo.TextInput{
+							CustomID:    "location",
+							Label:       "Location Name",
+							Placeholder: "Luxx",
+							Style:       discordgo.TextInputShort,
+							Required:    true,
+							MinLength:   1,
+							MaxLength:   100,
+						},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		log.WithField("error", err).Error("Failed to present location search")
+	}
+}
+
+// RegisterElsewhereModalHandler presents the locations matching the user's search as a select menu.
+func RegisterElsewhereModalHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+	data := interaction.ModalSubmitData()
+	_, submissionId, _ := strings.Cut(data.CustomID, ":")
+	query := data.Components[0].(*discordgo.ActionsRow).Components[0].(*discordgo.TextInput).Value
+
+	locations := FilterLocations(GetLocations(), query, 25, 0)
+	if len(locations) == 0 {
+		HandleError(session, interaction, nil, fmt.Sprintf(":x: No locations match \"%s\".", query))
+		return
+	}
+
+	options := make([]discordgo.SelectMenuOption, len(locations))
+	for i, location := range locations {
+		options[i] = discordgo.SelectMenuOption{
+			Label:       location.name,
+			Value:       strconv.Itoa(int(location.id)),
+			Description: location.address,
 		}
 	}
 
-	// TODO: Edit response to indicate success/failure
-	// TODO: Validate license plate
+	err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: fmt.Sprintf("%d location%s matched. Choose where to register:", len(locations), Plural(len(locations))),
+			Flags:   discordgo.MessageFlagsEphemeral,
+			Components: []discordgo.MessageComponent{
+				discordgo.ActionsRow{
+					Components: []discordgo.MessageComponent{
+						discordgo.SelectMenu{
+							MenuType:    discordgo.StringSelectMenu,
+							CustomID:    "register-location:" + submissionId,
+							Placeholder: "Location",
+							Options:     options,
+						},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		log.WithField("error", err).Error("Failed to present location picker")
+	}
+}
+
+// RegisterLocationHandler repeats a previous registration at the property chosen from the location picker.
+// The user's stored guest code (and apartment) for the new property is used, if one is required.
+func RegisterLocationHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+	data := interaction.MessageComponentData()
+	submission, ok := getRepeatableSubmission(session, interaction, data.CustomID)
+	if !ok {
+		return
+	}
+
+	locationId, parseErr := strconv.Atoi(data.Values[0])
+	if parseErr != nil {
+		HandleError(session, interaction, parseErr, "Error occurred while parsing location id")
+		return
+	}
+
+	code, _ := GetCode(int64(locationId), submission.Member)
+	form := FetchForm(NewCapture(interaction), uint(locationId), code)
+	if form.err != nil && !form.requireGuestCode {
+		HandleError(session, interaction, form.err, ":x: Failed to retrieve the registration form.")
+		return
+	} else if form.requireGuestCode {
+		if code == "" {
+			SetCodeRequirement(int64(locationId), true)
+			HandleError(session, interaction, nil, ":x: This location requires a guest code. Set one with `/code set` first.")
+		} else {
+			HandleError(session, interaction, nil, ":x: This location requires a guest code and the one stored was not valid (and subsequently deleted).")
+			ForgetInvalidCode(session, int64(locationId), submission.Member)
+		}
+		return
+	}
+
+	context, err := NewRegisterContext(form, uint(locationId), code)
+	if err != nil {
+		HandleError(session, interaction, err, "Error occurred while parsing resident profile identifier")
+		return
+	}
 
-	// session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
-	// 	Type: discordgo.InteractionResponseChannelMessageWithSource,
-	// 	Data: &discordgo.InteractionResponseData{
-	// 		Embeds: []*discordgo.MessageEmbed{
-	// 			{
-	// 				Footer: &discordgo.MessageEmbedFooter{
-	// 					Text: GetFooterText(),
-	// 				},
-	// 				Description: "testing 123",
-	// 				Fields:      []*discordgo.MessageEmbedField{},
-	// 			},
-	// 		},
-	// 		AllowedMentions: &discordgo.MessageAllowedMentions{},
-	// 	},
-	// })
+	values := valuesAt(submission.Values, submission.HiddenKeys, uint(locationId), submission.Member)
+	PresentRegistration(session, interaction, context, form, values, submission.Email)
 }
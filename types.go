@@ -1,5 +1,11 @@
 package main
 
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
 type Location struct {
 	id      uint   // Used for registration internally
 	key     string // Used for registration form lookup
@@ -12,12 +18,123 @@ type Field struct {
 	id   string // The id of the field
 }
 
+// FormSchema is the cacheable structure of a property's registration form.
+type FormSchema struct {
+	PropertyName string        `json:"propertyName"`
+	Address      string        `json:"address"`
+	Fields       []SchemaField `json:"fields"`
+	HiddenInputs []string      `json:"hiddenInputs"`
+	GuestCode    bool          `json:"guestCode"` // Whether the form was retrieved with a guest code
+	FetchedAt    time.Time     `json:"fetchedAt"`
+}
+
+// SchemaField is a single visible field of a cached registration form.
+type SchemaField struct {
+	ID   string `json:"id"`
+	Text string `json:"text"`
+}
+
 // RegisterContext is used to store the contextual information used during registration.
 type RegisterContext struct {
-	hiddenKeys       []string // The form inputs that are hidden & unused
-	requiredFormKeys []string // The form inputs that arne't hidden - required to submit the form
-	propertyId       uint     // The property ID involved with the request
-	residentId       uint     // The resident ID involved with the request
+	hiddenKeys       []string          // The form inputs that are hidden & unused
+	requiredFormKeys []string          // The form inputs that arne't hidden - required to submit the form
+	propertyId       uint              // The property ID involved with the request
+	residentId       uint              // The resident ID involved with the request
+	guestCode        string            // The guest code used to retrieve the form, if any
+	sharedGuild      string            // The guild whose shared guest code was used instead, if any
+	sharedAction     string            // How the shared guest code is being used, as recorded in its audit trail
+	invite           string            // The visitor invite being redeemed, if any
+	presetValues     map[string]string // Form values supplied by someone else (i.e. the resident issuing an invite)
+	onBehalfOf       int               // The member the vehicle is being registered for, if not the member registering
+	event            string            // The event card being registered through, if any
+}
+
+// Submission is a persisted copy of the values used in a registration, allowing it to be repeated later.
+type Submission struct {
+	Member       int               `json:"member"`                 // The member who submitted the registration
+	PropertyId   uint              `json:"propertyId"`             // The property the vehicle was registered at
+	ResidentId   uint              `json:"residentId"`             // The resident profile ID used
+	HiddenKeys   []string          `json:"hiddenKeys"`             // The hidden form inputs submitted empty
+	GuestCode    string            `json:"guestCode,omitempty"`    // The guest code used to retrieve the form, if any
+	SharedBy     string            `json:"sharedBy,omitempty"`     // The guild whose shared guest code was used instead, if any
+	Event        string            `json:"event,omitempty"`        // The event card whose guest code was used instead, if any
+	Values       map[string]string `json:"values"`                 // Form values keyed by the form input's id
+	Email        string            `json:"email,omitempty"`        // The email address the confirmation was sent to, if any
+	Expiry       time.Time         `json:"expiry,omitempty"`       // When the registration lapses, if it was approved
+	RegisteredBy int               `json:"registeredBy,omitempty"` // The member who registered on the member's behalf, if anyone
+}
+
+// SharedCode grants a guild's roles and members use of a guest code stored by its owner, without revealing it.
+type SharedCode struct {
+	Guild      string    `json:"guild"`      // The guild the code is shared within
+	PropertyId uint      `json:"propertyId"` // The property the code is for
+	Owner      int       `json:"owner"`      // The member whose stored code is shared
+	Roles      []string  `json:"roles"`      // Roles allowed to use the code
+	Members    []string  `json:"members"`    // Members allowed to use the code, regardless of their roles
+	SharedAt   time.Time `json:"sharedAt"`
+}
+
+// CodeUse is an entry in the audit trail of a shared guest code.
+type CodeUse struct {
+	Member int       `json:"member"` // The member who used (or changed) the shared code
+	Action string    `json:"action"` // What the code was used for, e.g. 'register' or 'renew'
+	At     time.Time `json:"at"`
+}
+
+// Invite lets a visitor register a vehicle at a resident's property with the resident's stored guest code, without seeing it.
+type Invite struct {
+	ID         string            `json:"id"`
+	Resident   int               `json:"resident"`   // The member who issued the invite
+	PropertyId uint              `json:"propertyId"` // The property visitors are registered at
+	Values     map[string]string `json:"values"`     // Non-vehicle form values supplied by the resident, e.g. their apartment number
+	MaxUses    int               `json:"maxUses"`    // The number of registrations the invite allows
+	Expires    time.Time         `json:"expires"`
+}
+
+// ParkingRequest is a guest's request to be registered at a resident's property, awaiting the resident's approval.
+type ParkingRequest struct {
+	ID         string            `json:"id"`
+	Guest      int               `json:"guest"`      // The member asking to be registered
+	Resident   int               `json:"resident"`   // The member whose guest code & apartment are used if approved
+	PropertyId uint              `json:"propertyId"` // The property the guest wants to park at
+	Values     map[string]string `json:"values"`     // The vehicle form values provided by the guest
+	Expires    time.Time         `json:"expires"`    // When the request lapses if the resident hasn't responded
+	ChannelId  string            `json:"channelId"`  // The resident's DM channel holding the approval message
+	MessageId  string            `json:"messageId"`  // The approval message sent to the resident
+}
+
+// EventCard is a message posted for an event's attendees to register their vehicles at its property.
+type EventCard struct {
+	ID         string    `json:"id"`
+	Guild      string    `json:"guild"`               // The guild the card was posted in
+	ChannelId  string    `json:"channelId"`           // The channel holding the card's message
+	MessageId  string    `json:"messageId"`           // The card's message, updated as attendees register
+	Organizer  int       `json:"organizer"`           // The member who posted the card
+	PropertyId uint      `json:"propertyId"`          // The property attendees park at
+	GuestCode  string    `json:"guestCode,omitempty"` // The guest code attendees register with, without seeing it
+	EventId    string    `json:"eventId,omitempty"`   // The guild's scheduled event the card is linked to, if any
+	EventName  string    `json:"eventName,omitempty"` // The scheduled event's name at the time the card was posted
+	Date       time.Time `json:"date"`                // The day of the event
+	Closes     time.Time `json:"closes"`              // When registration through the card ends
+}
+
+// Job is a unit of work persisted in Redis, executed by the scheduler once its time arrives.
+type Job struct {
+	ID       string    `json:"id"`                 // Unique identifier of the job
+	Kind     string    `json:"kind"`               // Selects the handler within jobHandlers
+	Target   string    `json:"target"`             // The identifier of whatever the job acts upon, interpreted by the handler
+	Attempts int       `json:"attempts"`           // The number of failed attempts so far
+	Deadline time.Time `json:"deadline,omitempty"` // When retries must have run by, if the job is pointless afterwards
+}
+
+// Renewal describes a registration that is automatically repeated before it expires.
+type Renewal struct {
+	ID           string     `json:"id"`                     // Unique identifier of the renewal
+	SubmissionID string     `json:"submissionId,omitempty"` // The submission renewed, which has at most one renewal
+	Submission   Submission `json:"submission"`             // The values registered with each renewal
+	Expiry       time.Time  `json:"expiry"`                 // When the current registration lapses
+	Until        time.Time  `json:"until,omitempty"`        // When renewals stop, or zero to renew until cancelled
+	Count        int        `json:"count"`                  // The number of renewals performed so far
 }
 
 const (
@@ -25,3 +142,89 @@ const (
 	GuestCodeNotRequired = iota
 	Unknown              = iota
 )
+
+// Statuses of a stored guest code, as last checked against Register2Park.
+const (
+	CodeVerified   = "verified"   // Accepted by Register2Park
+	CodeUnverified = "unverified" // Not yet checked, as Register2Park could not be reached
+	CodeInvalid    = "invalid"    // Rejected by Register2Park, likely rotated by the property manager
+)
+
+// VehicleProfile is a set of form values saved after a successful registration, re-used to fill future forms.
+type VehicleProfile struct {
+	Plate  string            `json:"plate"`           // The license plate, used as the profile's identifier
+	Values map[string]string `json:"values"`          // Form values keyed by the form input's id
+	Email  string            `json:"email,omitempty"` // The email address confirmations were sent to, if any
+}
+
+// Describe returns a short human-readable label for the profile, such as "Honda Accord (ABC123)".
+func (profile VehicleProfile) Describe() string {
+	description := strings.TrimSpace(profile.Values["vehicleMake"] + " " + profile.Values["vehicleModel"])
+	if description == "" {
+		return profile.Plate
+	}
+	return fmt.Sprintf("%s (%s)", description, profile.Plate)
+}
+
+// VehicleBackup is the portable copy of a member's saved vehicles (and optionally guest codes) used by /vehicle import & export.
+type VehicleBackup struct {
+	Vehicles []VehicleProfile `json:"vehicles"`
+	Codes    []BackupCode     `json:"codes,omitempty"`
+}
+
+// BackupCode is a guest code stored for a location, within a VehicleBackup.
+type BackupCode struct {
+	Location uint   `json:"location"`
+	Code     string `json:"code"`
+}
+
+// MemberData is everything stored about a member, as attached by /mydata.
+type MemberData struct {
+	Member          int                   `json:"member"`
+	NeverStore      bool                  `json:"neverStore"`                 // Whether the member opted out of storing their details
+	ReminderLead    *int                  `json:"reminderLeadMinutes"`        // The member's reminder preference, if they chose one
+	Codes           []StoredCode          `json:"codes"`                      // Guest codes stored by the member
+	Apartments      map[uint]string       `json:"apartments"`                 // Apartment numbers, keyed by property
+	Vehicles        []VehicleProfile      `json:"vehicles"`                   // Saved vehicle profiles
+	History         []HistoryEntry        `json:"history"`                    // Registration history, newest first
+	Submissions     map[string]Submission `json:"submissions"`                // Recent registrations that can be repeated, keyed by ID
+	Renewals        []Renewal             `json:"renewals"`                   // Active auto-renewals
+	Invites         []Invite              `json:"invites"`                    // Outstanding visitor invites issued by the member
+	SharedCodes     []SharedCode          `json:"sharedCodes"`                // Guest codes the member shares within guilds
+	SharedCodeUses  []SharedCodeUse       `json:"sharedCodeUses"`             // Uses of guest codes shared with the member
+	ParkingRequests []ParkingRequest      `json:"parkingRequests"`            // Pending parking requests made by or to the member
+	EventCards      []EventCard           `json:"eventCards"`                 // Open event cards posted by the member
+	Attendance      map[string]string     `json:"eventAttendance,omitempty"`  // Outcomes of the member's registrations, keyed by event card
+	Captures        []HAREntry            `json:"capturedRequests,omitempty"` // Register2Park traffic recorded for the member's registrations
+}
+
+// StoredCode is a guest code stored by a member, along with its last known status.
+type StoredCode struct {
+	Location uint   `json:"location"`
+	Code     string `json:"code"`
+	Status   string `json:"status"`
+}
+
+// SharedCodeUse is a use of a shared guest code, identifying which code was used.
+type SharedCodeUse struct {
+	Guild      string `json:"guild"`
+	PropertyId uint   `json:"propertyId"`
+	CodeUse
+}
+
+// HistoryEntry records the outcome of a single registration attempt within a member's ledger.
+type HistoryEntry struct {
+	ID               string    `json:"id"`                         // Unique identifier of the attempt
+	Member           int       `json:"member"`                     // The member the vehicle was registered for
+	PropertyId       uint      `json:"propertyId"`                 // The property registered at
+	PropertyName     string    `json:"propertyName"`               // The property's name at the time of registration
+	Plate            string    `json:"plate"`                      // The license plate registered
+	Make             string    `json:"make,omitempty"`             // The vehicle's make, if the form asked for it
+	Model            string    `json:"model,omitempty"`            // The vehicle's model, if the form asked for it
+	Approved         bool      `json:"approved"`                   // Whether the registration was approved or denied
+	ConfirmationCode string    `json:"confirmationCode,omitempty"` // The confirmation code, if approved
+	VehicleId        string    `json:"vehicleId,omitempty"`        // Register2Park's identifier for the vehicle, if approved
+	RegisteredAt     time.Time `json:"registeredAt"`               // When the registration was made
+	ExpiresAt        time.Time `json:"expiresAt,omitempty"`        // When the registration lapses, if approved
+	RegisteredBy     int       `json:"registeredBy,omitempty"`     // The member who registered on the member's behalf, if anyone
+}
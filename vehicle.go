@@ -0,0 +1,418 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/samber/lo"
+	"github.com/sirupsen/logrus"
+)
+
+const maxBackupRows = 100 // The most vehicles (or codes) accepted in a single import
+
+var platePattern = regexp.MustCompile(`^[A-Z0-9 -]{1,10}$`)
+
+// backupColumns are the leading columns of a CSV vehicle backup, paired with the form inputs they hold.
+// Other form inputs follow as columns named by their id; 'email', 'location' and 'code' aren't form inputs.
+var backupColumns = [][2]string{
+	{"plate", "vehicleLicensePlate"},
+	{"make", "vehicleMake"},
+	{"model", "vehicleModel"},
+	{"apt", "vehicleApt"},
+	{"email", ""},
+	{"location", ""},
+	{"code", ""},
+}
+
+// NewVehicleBackup collects a member's saved vehicles, and their guest codes if requested.
+func NewVehicleBackup(member int, includeCodes bool) VehicleBackup {
+	backup := VehicleBackup{Vehicles: GetVehicles(member)}
+	if includeCodes {
+		for location, code := range GetCodes(member) {
+			backup.Codes = append(backup.Codes, BackupCode{Location: location, Code: code})
+		}
+		slices.SortFunc(backup.Codes, func(a, b BackupCode) int {
+			return int(a.Location) - int(b.Location)
+		})
+	}
+	return backup
+}
+
+// WriteVehicleBackupCSV writes a backup as CSV, with a row for each vehicle followed by a row for each guest code.
+func WriteVehicleBackupCSV(writer io.Writer, backup VehicleBackup) error {
+	// Form inputs beyond the usual ones get a column of their own
+	known := lo.Map(backupColumns, func(column [2]string, _ int) string { return column[1] })
+	var extra []string
+	for _, vehicle := range backup.Vehicles {
+		for key := range vehicle.Values {
+			if key != "vehicleLicensePlateConfirm" && !slices.Contains(known, key) && !slices.Contains(extra, key) {
+				extra = append(extra, key)
+			}
+		}
+	}
+	slices.Sort(extra)
+
+	header := append(lo.Map(backupColumns, func(column [2]string, _ int) string { return column[0] }), extra...)
+	csvWriter := csv.NewWriter(writer)
+	if err := csvWriter.Write(header); err != nil {
+		return err
+	}
+
+	for _, vehicle := range backup.Vehicles {
+		row := []string{vehicle.Plate, vehicle.Values["vehicleMake"], vehicle.Values["vehicleModel"], vehicle.Values["vehicleApt"], vehicle.Email, "", ""}
+		for _, key := range extra {
+			row = append(row, vehicle.Values[key])
+		}
+		if err := csvWriter.Write(row); err != nil {
+			return err
+		}
+	}
+	for _, code := range backup.Codes {
+		row := []string{"", "", "", "", "", strconv.FormatUint(uint64(code.Location), 10), code.Code}
+		row = append(row, make([]string, len(extra))...)
+		if err := csvWriter.Write(row); err != nil {
+			return err
+		}
+	}
+
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// ReadVehicleBackupCSV reads a backup written by WriteVehicleBackupCSV.
+// Rows with a plate hold a vehicle, while rows with a location or code hold a guest code; a row may hold both.
+func ReadVehicleBackupCSV(reader io.Reader) (VehicleBackup, error) {
+	var backup VehicleBackup
+	csvReader := csv.NewReader(reader)
+	csvReader.TrimLeadingSpace = true
+	csvReader.FieldsPerRecord = -1
+
+	header, err := csvReader.Read()
+	if err != nil {
+		return backup, fmt.Errorf("failed to read header row: %w", err)
+	}
+	columns := lo.Map(header, func(name string, _ int) string {
+		return csvField(name)
+	})
+
+	for {
+		row, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return backup, err
+		}
+
+		vehicle := VehicleProfile{Values: map[string]string{}}
+		var location, code string
+		for index, value := range row {
+			if index >= len(columns) || strings.TrimSpace(value) == "" {
+				continue
+			}
+			value = strings.TrimSpace(value)
+
+			switch strings.ToLower(columns[index]) {
+			case "email":
+				vehicle.Email = value
+			case "location":
+				location = value
+			case "code":
+				code = value
+			default:
+				vehicle.Values[columns[index]] = value
+			}
+		}
+
+		if plate := vehicle.Values["vehicleLicensePlate"]; plate != "" {
+			vehicle.Plate = plate
+			backup.Vehicles = append(backup.Vehicles, vehicle)
+		}
+		if location != "" || code != "" {
+			// Locations that aren't numeric are kept as zero, which is reported as invalid
+			locationId, _ := strconv.ParseUint(location, 10, 64)
+			backup.Codes = append(backup.Codes, BackupCode{Location: uint(locationId), Code: code})
+		}
+	}
+
+	return backup, nil
+}
+
+// ReadVehicleBackup reads a backup in the given format, either "csv" or "json".
+func ReadVehicleBackup(reader io.Reader, format string) (VehicleBackup, error) {
+	switch format {
+	case "csv":
+		return ReadVehicleBackupCSV(reader)
+	case "json":
+		var backup VehicleBackup
+		err := json.NewDecoder(reader).Decode(&backup)
+		return backup, err
+	}
+	return VehicleBackup{}, fmt.Errorf("unknown backup format: %s", format)
+}
+
+// WriteVehicleBackup writes a backup in the given format, either "csv" or "json".
+func WriteVehicleBackup(writer io.Writer, backup VehicleBackup, format string) error {
+	switch format {
+	case "csv":
+		return WriteVehicleBackupCSV(writer, backup)
+	case "json":
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(backup)
+	}
+	return fmt.Errorf("unknown backup format: %s", format)
+}
+
+// NormalizeVehicleBackup tidies the values of a backup, then returns a description of every invalid vehicle and code.
+// Plates are upper-cased & confirmed, as a registration form expects.
+func NormalizeVehicleBackup(backup *VehicleBackup) []string {
+	var problems []string
+	if len(backup.Vehicles) > maxBackupRows || len(backup.Codes) > maxBackupRows {
+		return []string{fmt.Sprintf("At most %d vehicles and %d codes can be imported at once.", maxBackupRows, maxBackupRows)}
+	}
+
+	for index := range backup.Vehicles {
+		vehicle := &backup.Vehicles[index]
+		if vehicle.Values == nil {
+			vehicle.Values = map[string]string{}
+		}
+		if vehicle.Plate == "" {
+			vehicle.Plate = vehicle.Values["vehicleLicensePlate"]
+		}
+		vehicle.Plate = strings.ToUpper(strings.TrimSpace(vehicle.Plate))
+		vehicle.Values["vehicleLicensePlate"] = vehicle.Plate
+		vehicle.Values["vehicleLicensePlateConfirm"] = vehicle.Plate
+
+		label := fmt.Sprintf("Vehicle %d", index+1)
+		if !platePattern.MatchString(vehicle.Plate) {
+			problems = append(problems, fmt.Sprintf("%s: the plate `%s` is not valid.", label, vehicle.Plate))
+		}
+		if vehicle.Email != "" {
+			if _, err := mail.ParseAddress(vehicle.Email); err != nil {
+				problems = append(problems, fmt.Sprintf("%s (%s): the email address is not valid.", label, vehicle.Plate))
+			}
+		}
+	}
+
+	for index, code := range backup.Codes {
+		label := fmt.Sprintf("Code %d", index+1)
+		if !LocationExists(int64(code.Location)) {
+			problems = append(problems, fmt.Sprintf("%s: the location `%d` does not exist.", label, code.Location))
+		} else if !codePattern.MatchString(code.Code) {
+			problems = append(problems, fmt.Sprintf("%s (%s): the code contains invalid characters.", label, GetLocationName(code.Location)))
+		}
+	}
+
+	return problems
+}
+
+// backupFormat infers the format of an attached backup from its file name, falling back to its content type.
+func backupFormat(attachment *discordgo.MessageAttachment) (string, error) {
+	switch strings.ToLower(path.Ext(attachment.Filename)) {
+	case ".csv":
+		return "csv", nil
+	case ".json":
+		return "json", nil
+	}
+
+	switch {
+	case strings.HasPrefix(attachment.ContentType, "text/csv"):
+		return "csv", nil
+	case strings.HasPrefix(attachment.ContentType, "application/json"):
+		return "json", nil
+	}
+	return "", errors.New("the file must be a .csv or .json file")
+}
+
+var VehicleCommandDefinition = &discordgo.ApplicationCommand{
+	Name:        "vehicle",
+	Description: "Manage your saved vehicles",
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "import",
+			Description: "Save vehicles (and guest codes) from a CSV or JSON file",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionAttachment,
+					Name:        "file",
+					Description: "A file in the format produced by /vehicle export",
+					Required:    true,
+				},
+			},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "export",
+			Description: "Download your saved vehicles as a file",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "format",
+					Description: "The file format",
+					Required:    true,
+					Choices: []*discordgo.ApplicationCommandOptionChoice{
+						{Name: "CSV", Value: "csv"},
+						{Name: "JSON", Value: "json"},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Name:        "codes",
+					Description: "Include your stored guest codes, unmasked",
+					Required:    false,
+				},
+			},
+		},
+	},
+}
+
+func VehicleCommandHandler(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+	log := logrus.WithFields(logrus.Fields{
+		"interaction": interaction.ID,
+		"user":        GetUser(interaction).ID,
+		"command":     "vehicle",
+	})
+
+	userId, parseErr := strconv.Atoi(GetUser(interaction).ID)
+	if parseErr != nil {
+		HandleError(session, interaction, parseErr, "Error occurred while parsing user id")
+		return
+	}
+
+	data := interaction.ApplicationCommandData()
+	subcommand := data.Options[0]
+	options := lo.SliceToMap(subcommand.Options, func(option *discordgo.ApplicationCommandInteractionDataOption) (string, *discordgo.ApplicationCommandInteractionDataOption) {
+		return option.Name, option
+	})
+
+	switch subcommand.Name {
+
+	case "export":
+		format := options["format"].StringValue()
+		includeCodes := false
+		if option, ok := options["codes"]; ok {
+			includeCodes = option.BoolValue()
+		}
+
+		var buffer bytes.Buffer
+		if err := WriteVehicleBackup(&buffer, NewVehicleBackup(userId, includeCodes), format); err != nil {
+			HandleError(session, interaction, err, "Failed to export vehicles")
+			return
+		}
+
+		err := session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Flags: discordgo.MessageFlagsEphemeral,
+				Files: []*discordgo.File{
+					{
+						Name:        "vehicles." + format,
+						ContentType: map[string]string{"csv": "text/csv", "json": "application/json"}[format],
+						Reader:      &buffer,
+					},
+				},
+			},
+		})
+		if err != nil {
+			log.WithField("error", err).Error("Failed to respond with vehicle export")
+		}
+
+	case "import":
+		attachment := data.Resolved.Attachments[options["file"].Value.(string)]
+		format, err := backupFormat(attachment)
+		if err != nil {
+			HandleError(session, interaction, nil, ":x: The file must be a `.csv` or `.json` file, as produced by `/vehicle export`.")
+			return
+		}
+
+		// Downloading the file and checking guest codes can take a while
+		err = session.InteractionRespond(interaction.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Flags: discordgo.MessageFlagsEphemeral,
+			},
+		})
+		if err != nil {
+			log.WithField("error", err).Error("Failed to defer vehicle import response")
+			return
+		}
+
+		contents, err := downloadAttachment(attachment)
+		if err != nil {
+			HandleError(session, interaction, err, ":x: Failed to download the file.")
+			return
+		}
+		backup, err := ReadVehicleBackup(bytes.NewReader(contents), format)
+		if err != nil {
+			HandleError(session, interaction, err, ":x: Failed to read the file.")
+			return
+		}
+
+		content := ImportVehicleBackup(NewCapture(interaction), userId, backup)
+		log.WithFields(logrus.Fields{"vehicles": len(backup.Vehicles), "codes": len(backup.Codes)}).Info("Imported vehicle backup")
+
+		_, err = session.InteractionResponseEdit(interaction.Interaction, &discordgo.WebhookEdit{
+			Content: &content,
+		})
+		if err != nil {
+			log.WithField("error", err).Warn("Unable to provide vehicle import result to user")
+		}
+	}
+}
+
+// ImportVehicleBackup validates every vehicle & code of a backup, storing them only if all are valid.
+// Guest codes are checked with Register2Park like /code set; codes that can't be checked are stored unverified.
+// Returns a description of the outcome for the member.
+func ImportVehicleBackup(capture Capture, member int, backup VehicleBackup) string {
+	problems := NormalizeVehicleBackup(&backup)
+
+	// Codes are only worth checking with Register2Park once they're known to be well-formed
+	statuses := make([]string, len(backup.Codes))
+	if len(problems) == 0 {
+		for index, code := range backup.Codes {
+			switch verified, accepted := ValidateCode(capture, int64(code.Location), code.Code); {
+			case !accepted:
+				problems = append(problems, fmt.Sprintf("Code %d (%s): the code was not accepted by Register2Park.", index+1, GetLocationName(code.Location)))
+			case !verified:
+				statuses[index] = CodeUnverified
+			default:
+				statuses[index] = CodeVerified
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		description := fmt.Sprintf(":x: Nothing was imported, as %d problem%s %s found:\n- %s",
+			len(problems), Plural(len(problems)), lo.Ternary(len(problems) == 1, "was", "were"), strings.Join(problems, "\n- "))
+		if utf8.RuneCountInString(description) > 2000 {
+			description = TruncateRunes(description, 1997) + "..."
+		}
+		return description
+	}
+	if len(backup.Vehicles) == 0 && len(backup.Codes) == 0 {
+		return ":x: The file doesn't contain any vehicles or codes."
+	}
+
+	for _, vehicle := range backup.Vehicles {
+		StoreVehicle(member, vehicle)
+	}
+	for index, code := range backup.Codes {
+		StoreCode(code.Code, int64(code.Location), member, statuses[index])
+	}
+
+	return fmt.Sprintf(":white_check_mark: Imported %d vehicle%s and %d guest code%s.",
+		len(backup.Vehicles), Plural(len(backup.Vehicles)), len(backup.Codes), Plural(len(backup.Codes)))
+}